@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+const (
+	// CodeUnauthorized is a JSON-RPC error code for requests without valid credentials.
+	CodeUnauthorized = http.StatusUnauthorized
+
+	// CodeForbidden is a JSON-RPC error code for requests without required permissions.
+	CodeForbidden = http.StatusForbidden
+)
+
+// DefaultAPIKeyHeader is a default header for API key.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+type principalKey struct{}
+
+// Principal is an authenticated client.
+type Principal struct {
+	// ID is used in logs and metrics.
+	ID string
+
+	// Scopes are allowed methods: `namespace.method`, `namespace`, `namespace.*` or `*`.
+	Scopes []string
+}
+
+// KeyStore resolves API keys to principals. Implementation must be safe for concurrent use.
+type KeyStore interface {
+	// Principal returns principal by API key or nil if key is unknown.
+	Principal(ctx context.Context, key string) (*Principal, error)
+}
+
+// StaticKeyStore is a KeyStore with fixed API keys.
+type StaticKeyStore map[string]Principal
+
+// Principal implements KeyStore.
+func (s StaticKeyStore) Principal(_ context.Context, key string) (*Principal, error) {
+	p, ok := s[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &p, nil
+}
+
+// APIKeyOptions configures WithAPIKeyAuth.
+type APIKeyOptions struct {
+	// Header is a request header with API key. Default is DefaultAPIKeyHeader.
+	Header string
+
+	// QueryParam is a query parameter with API key, it is used if header is empty. Disabled by default.
+	QueryParam string
+
+	// Public are methods allowed without API key in the same format as Principal.Scopes.
+	Public []string
+}
+
+// WithAPIKeyAuth authenticates requests by API key from header or query parameter via KeyStore.
+// It returns CodeUnauthorized error for missing or unknown key and CodeForbidden error if principal has no scope for method.
+// Public methods are called without principal if key is missing or unknown.
+// Principal is set to context and could be retrieved via PrincipalFromContext. It is also visible to middlewares
+// placed before WithAPIKeyAuth (e.g. metrics and logs), if WithHeaders is used before them.
+func WithAPIKeyAuth(store KeyStore, opts APIKeyOptions) zenrpc.MiddlewareFunc {
+	if opts.Header == "" {
+		opts.Header = DefaultAPIKeyHeader
+	}
+
+	public := Principal{Scopes: opts.Public}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+
+			key := ""
+			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+				key = req.Header.Get(opts.Header)
+				if key == "" && opts.QueryParam != "" {
+					key = req.URL.Query().Get(opts.QueryParam)
+				}
+			}
+
+			var p *Principal
+			if key != "" {
+				var err error
+				if p, err = store.Principal(ctx, key); err != nil {
+					r := zenrpc.NewResponseError(nil, http.StatusInternalServerError, "Internal error", nil)
+					r.Error.Err = err
+					return r
+				}
+			}
+
+			// unknown key is the same as missing key
+			if p == nil {
+				if public.Allowed(namespace, method) {
+					return h(ctx, method, params)
+				}
+
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Unauthorized", nil)
+			}
+
+			// principal is set before scope check, so forbidden requests are logged with it
+			ctx = NewPrincipalContext(ctx, p)
+			if !p.Allowed(namespace, method) && !public.Allowed(namespace, method) {
+				return zenrpc.NewResponseError(nil, CodeForbidden, "Forbidden", nil)
+			}
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// Allowed checks principal scopes for method.
+func (p Principal) Allowed(namespace, method string) bool {
+	namespace, method = strings.ToLower(namespace), strings.ToLower(method)
+	for _, scope := range p.Scopes {
+		if scope == "" {
+			continue
+		}
+
+		switch strings.ToLower(scope) {
+		case "*", namespace, namespace + ".*", namespace + "." + method:
+			return true
+		}
+	}
+
+	return false
+}
+
+// principalHolder is a Principal slot in context. It is created empty by WithHeaders and is filled by auth
+// middlewares, so principal is available to middlewares placed before them.
+type principalHolder struct {
+	p atomic.Pointer[Principal]
+}
+
+// NewPrincipalContext creates new context with Principal. Empty principal slot from WithHeaders is filled instead.
+func NewPrincipalContext(ctx context.Context, p *Principal) context.Context {
+	if ph, ok := ctx.Value(principalKey{}).(*principalHolder); ok && ph.p.CompareAndSwap(nil, p) {
+		return ctx
+	}
+
+	ph := &principalHolder{}
+	ph.p.Store(p)
+	return context.WithValue(ctx, principalKey{}, ph)
+}
+
+// newPrincipalHolderContext creates new context with empty principal slot.
+func newPrincipalHolderContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, principalKey{}, &principalHolder{})
+}
+
+// PrincipalFromContext returns Principal from context.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	ph, _ := ctx.Value(principalKey{}).(*principalHolder)
+	if ph == nil {
+		return nil
+	}
+
+	return ph.p.Load()
+}
@@ -0,0 +1,106 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+type errKeyStore struct{}
+
+func (errKeyStore) Principal(context.Context, string) (*middleware.Principal, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestMiddlewareAPIKeyAuth(t *testing.T) {
+	store := middleware.StaticKeyStore{
+		"full":   {ID: "full", Scopes: []string{"arith"}},
+		"divide": {ID: "divide", Scopes: []string{"arith.divide", "other.*"}},
+	}
+
+	reg := prometheus.NewRegistry()
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithHeaders(),
+		middleware.WithMetricsOpts("test", middleware.MetricsOptions{Registerer: reg, PrincipalLabel: true}),
+		middleware.WithAPIKeyAuth(store, middleware.APIKeyOptions{QueryParam: "key", Public: []string{"arith.divide"}}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	tc := []struct {
+		key, method, out string
+	}{
+		{"", "arith.multiply", `{"jsonrpc":"2.0","id":1,"error":{"code":401,"message":"Unauthorized"}}`},
+		{"unknown", "arith.multiply", `{"jsonrpc":"2.0","id":1,"error":{"code":401,"message":"Unauthorized"}}`},
+		{"", "arith.divide", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"unknown", "arith.divide", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"divide", "arith.multiply", `{"jsonrpc":"2.0","id":1,"error":{"code":403,"message":"Forbidden"}}`},
+		{"full", "arith.multiply", `{"jsonrpc":"2.0","id":1,"result":2}`},
+	}
+
+	for _, c := range tc {
+		in := `{"jsonrpc": "2.0", "method": "` + c.method + `", "params": { "a": 1, "b": 2 }, "id": 1 }`
+		res, err := http.Post(ts.URL+"?key="+c.key, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(resp) != c.out {
+			t.Errorf("key=%s method=%s: got %s expected %s", c.key, c.method, resp, c.out)
+		}
+	}
+
+	// principal is visible to metrics placed before auth, rejected requests are counted
+	expected := `
+# HELP app_rpc_error_requests_total Error requests count by method and error code.
+# TYPE app_rpc_error_requests_total counter
+app_rpc_error_requests_total{code="401",method="arith.multiply",platform="",principal="",server="test",version=""} 2
+app_rpc_error_requests_total{code="403",method="arith.multiply",platform="",principal="divide",server="test",version=""} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_error_requests_total"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(reg, "app_rpc_request_size_bytes"); n != 4 {
+		t.Errorf("got %d request size series, expected 4", n)
+	}
+}
+
+func TestMiddlewareAPIKeyAuthStoreError(t *testing.T) {
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(middleware.WithAPIKeyAuth(errKeyStore{}, middleware.APIKeyOptions{}))
+	rpc.Register("arith", testdata.ArithService{})
+
+	in := `{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 2 }, "id": 1 }`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(in))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(middleware.DefaultAPIKeyHeader, "key")
+
+	w := httptest.NewRecorder()
+	rpc.ServeHTTP(w, req)
+
+	if out := w.Body.String(); out != `{"jsonrpc":"2.0","id":1,"error":{"code":500,"message":"Internal error"}}` {
+		t.Errorf("got %s, expected internal error without details", out)
+	}
+}
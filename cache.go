@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache results for `Cache` field in response extensions and result label of cache metric.
+const (
+	CacheHit   = "hit"
+	CacheStale = "stale"
+	CacheMiss  = "miss"
+)
+
+// CacheEntry is a cached method result.
+type CacheEntry struct {
+	Result    json.RawMessage
+	CreatedAt time.Time
+}
+
+// CacheStore stores cached results. Implementation must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns entry by key or nil if entry is not found.
+	Get(ctx context.Context, key string) (*CacheEntry, error)
+
+	// Set saves entry for ttl.
+	Set(ctx context.Context, key string, e CacheEntry, ttl time.Duration) error
+}
+
+// CacheRule configures caching for method.
+type CacheRule struct {
+	// TTL is a time while cached result is fresh.
+	TTL time.Duration
+
+	// Stale is a time after TTL while stale result is returned and is refreshed in background.
+	Stale time.Duration
+
+	// VaryPlatform, VaryVersion and VaryCountry add platform, version and country from context to cache key.
+	VaryPlatform bool
+	VaryVersion  bool
+	VaryCountry  bool
+}
+
+// CacheOptions configures WithCache.
+type CacheOptions struct {
+	// Rules are cache rules by `namespace.method`, `namespace` or `*` keys. The most specific rule is used.
+	Rules map[string]CacheRule
+
+	// ServerName is used in metrics.
+	ServerName string
+
+	// IsDevel or AllowDebugFunc enable `Cache` field in response extensions.
+	IsDevel        bool
+	AllowDebugFunc AllowDebugFunc
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// WithCache caches successful results by method and canonical params. Concurrent misses are collapsed into one call.
+// Cache result (hit, stale or miss) is set to `Cache` field in response extensions if debug is allowed.
+// It exposes metric `app_rpc_cache_requests_total` with labels: method, result, server.
+func WithCache(store CacheStore, opts CacheOptions) zenrpc.MiddlewareFunc {
+	if opts.ServerName == "" {
+		opts.ServerName = "rpc"
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	opts.Rules = lowerKeys(opts.Rules)
+
+	requests := registerCollector(opts.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "cache_requests_total",
+		Help:      "Cache requests count by method and result.",
+	}, []string{"method", "result", "server"}))
+
+	var group singleflight.Group
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			rule, ok := methodValue(opts.Rules, namespace, method)
+			if !ok || rule.TTL <= 0 {
+				return h(ctx, method, params)
+			}
+
+			name, key := namespace+"."+method, cacheKey(ctx, namespace, method, params, rule)
+
+			// call method and save successful result
+			load := func(ctx context.Context) zenrpc.Response {
+				v, _, shared := group.Do(key, func() (any, error) {
+					r := h(ctx, method, params)
+					if r.Error == nil && r.Result != nil {
+						_ = store.Set(ctx, key, CacheEntry{Result: *r.Result, CreatedAt: time.Now()}, rule.TTL+rule.Stale)
+					}
+
+					return r, nil
+				})
+
+				r := v.(zenrpc.Response) //nolint:errcheck // always zenrpc.Response
+				if shared {
+					r = copyResponse(r)
+				}
+
+				return r
+			}
+
+			var (
+				r   zenrpc.Response
+				age time.Duration
+			)
+
+			e, err := store.Get(ctx, key)
+			if err == nil && e != nil {
+				age = time.Since(e.CreatedAt)
+			}
+
+			result := CacheMiss
+			switch {
+			case err != nil || e == nil || age >= rule.TTL+rule.Stale:
+				r = load(ctx)
+			case age < rule.TTL:
+				result, r = CacheHit, cachedResponse(e)
+			default:
+				result, r = CacheStale, cachedResponse(e)
+				go func() {
+					// refresh is dropped on panic, it is not covered by WithRecover
+					defer func() { _ = recover() }()
+					load(context.WithoutCancel(ctx))
+				}()
+			}
+
+			if r.Error != nil && r.Error.Code == zenrpc.MethodNotFound {
+				return r
+			}
+
+			requests.WithLabelValues(name, result, opts.ServerName).Inc()
+			if debugAllowed(ctx, opts.IsDevel, opts.AllowDebugFunc) {
+				if r.Extensions == nil {
+					r.Extensions = make(map[string]interface{})
+				}
+				r.Extensions["Cache"] = result
+			}
+
+			return r
+		}
+	}
+}
+
+// cacheKey returns key by method, canonical params and context values.
+func cacheKey(ctx context.Context, namespace, method string, params json.RawMessage, rule CacheRule) string {
+	hash := sha256.New()
+	hash.Write(canonicalJSON(params))
+
+	if rule.VaryPlatform {
+		hash.Write([]byte("\nplatform=" + appkit.PlatformFromContext(ctx)))
+	}
+
+	if rule.VaryVersion {
+		hash.Write([]byte("\nversion=" + appkit.VersionFromContext(ctx)))
+	}
+
+	if rule.VaryCountry {
+		hash.Write([]byte("\ncountry=" + appkit.CountryFromContext(ctx)))
+	}
+
+	return namespace + "." + method + ":" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// canonicalJSON returns JSON with sorted keys and without spaces. Invalid JSON is returned as is.
+func canonicalJSON(data json.RawMessage) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return data
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+
+	return b
+}
+
+// copyResponse returns response with copied error and extensions, so it could be modified by next middlewares.
+func copyResponse(r zenrpc.Response) zenrpc.Response {
+	if r.Error != nil {
+		e := *r.Error
+		r.Error = &e
+	}
+
+	if r.Extensions != nil {
+		ext := make(map[string]interface{}, len(r.Extensions))
+		for k, v := range r.Extensions {
+			ext[k] = v
+		}
+		r.Extensions = ext
+	}
+
+	return r
+}
+
+// cachedResponse returns response with cached result.
+func cachedResponse(e *CacheEntry) zenrpc.Response {
+	result := e.Result
+	return zenrpc.Response{Version: zenrpc.Version, Result: (*json.RawMessage)(&result)}
+}
+
+// DefaultCacheMaxEntries is a default max number of entries in memory cache store.
+const DefaultCacheMaxEntries = 10000
+
+// memoryCacheStore is an in-memory CacheStore with LRU eviction.
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type memoryCacheEntry struct {
+	key     string
+	entry   CacheEntry
+	expires time.Time
+}
+
+// NewMemoryCacheStore returns in-memory CacheStore with up to maxEntries entries (DefaultCacheMaxEntries if zero).
+// Least recently used entries are evicted if store is full.
+func NewMemoryCacheStore(maxEntries int) *memoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+
+	return &memoryCacheStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (s *memoryCacheStore) Get(_ context.Context, key string) (*CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+
+	e := el.Value.(*memoryCacheEntry) //nolint:errcheck // always *memoryCacheEntry
+	if time.Now().After(e.expires) {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+		return nil, nil
+	}
+
+	s.lru.MoveToFront(el)
+	entry := e.entry
+	return &entry, nil
+}
+
+func (s *memoryCacheStore) Set(_ context.Context, key string, e CacheEntry, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	me := &memoryCacheEntry{key: key, entry: e, expires: time.Now().Add(ttl)}
+	if el, ok := s.entries[key]; ok {
+		el.Value = me
+		s.lru.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.lru.PushFront(me)
+	for s.lru.Len() > s.maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.entries, el.Value.(*memoryCacheEntry).key) //nolint:errcheck // always *memoryCacheEntry
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+// agingStore is a CacheStore with aged entries and notifications about saved entries.
+type agingStore struct {
+	middleware.CacheStore
+	age   atomic.Int64
+	saved chan struct{}
+}
+
+func newAgingStore() *agingStore {
+	return &agingStore{CacheStore: middleware.NewMemoryCacheStore(0), saved: make(chan struct{}, 10)}
+}
+
+func (s *agingStore) Get(ctx context.Context, key string) (*middleware.CacheEntry, error) {
+	e, err := s.CacheStore.Get(ctx, key)
+	if e != nil {
+		e.CreatedAt = e.CreatedAt.Add(-time.Duration(s.age.Load()))
+	}
+
+	return e, err
+}
+
+func (s *agingStore) Set(ctx context.Context, key string, e middleware.CacheEntry, ttl time.Duration) error {
+	s.age.Store(0)
+	err := s.CacheStore.Set(ctx, key, e, ttl)
+	s.saved <- struct{}{}
+	return err
+}
+
+func TestMiddlewareCache(t *testing.T) {
+	reg, store := prometheus.NewRegistry(), newAgingStore()
+
+	var calls atomic.Int32
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithCache(store, middleware.CacheOptions{
+			Rules:      map[string]middleware.CacheRule{"arith.divide": {TTL: time.Minute, Stale: time.Hour}},
+			ServerName: "test",
+			IsDevel:    true,
+			Registerer: reg,
+		}),
+		func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+			return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+				calls.Add(1)
+				return h(ctx, method, params)
+			}
+		},
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	do := func(params string) string {
+		in := `{"jsonrpc": "2.0", "method": "arith.divide", "params": ` + params + `, "id": 1 }`
+		res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(resp)
+	}
+
+	result := `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1},"extensions":{"Cache":"`
+	for i, c := range []struct {
+		params, cache string
+		calls         int32
+	}{
+		{`{"a": 1, "b": 2}`, "miss", 1},
+		{`{"b":2,"a":1}`, "hit", 1},
+		{`{"a": 1, "b": 2}`, "stale", 2},
+		{`{"a": 1, "b": 2}`, "hit", 2},
+	} {
+		if c.cache == "stale" {
+			store.age.Store(int64(2 * time.Minute))
+		}
+
+		if out, expected := do(c.params), result+c.cache+`"}}`; out != expected {
+			t.Errorf("%d: got %s expected %s", i, out, expected)
+		}
+
+		// wait for saved result, refresh of stale result is done in background
+		if c.cache != "hit" {
+			<-store.saved
+		}
+
+		if n := calls.Load(); n != c.calls {
+			t.Errorf("%d: got %d calls, expected %d", i, n, c.calls)
+		}
+	}
+
+	expected := `
+# HELP app_rpc_cache_requests_total Cache requests count by method and result.
+# TYPE app_rpc_cache_requests_total counter
+app_rpc_cache_requests_total{method="arith.divide",result="hit",server="test"} 2
+app_rpc_cache_requests_total{method="arith.divide",result="miss",server="test"} 1
+app_rpc_cache_requests_total{method="arith.divide",result="stale",server="test"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_cache_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMiddlewareCacheRefreshPanic(t *testing.T) {
+	store, refreshed := newAgingStore(), make(chan struct{})
+
+	var calls atomic.Int32
+	invoke := middleware.WithCache(store, middleware.CacheOptions{
+		Rules:      map[string]middleware.CacheRule{"*": {TTL: time.Minute, Stale: time.Hour}},
+		IsDevel:    true,
+		Registerer: prometheus.NewRegistry(),
+	})(func(context.Context, string, json.RawMessage) zenrpc.Response {
+		if calls.Add(1) == 2 {
+			close(refreshed)
+			panic("boom")
+		}
+
+		var r zenrpc.Response
+		r.Set(1)
+		return r
+	})
+
+	invoke(t.Context(), "get", nil)
+	<-store.saved
+
+	// panic in background refresh must not crash process, stale result is kept
+	store.age.Store(int64(2 * time.Minute))
+	for i := range 2 {
+		if r := invoke(t.Context(), "get", nil); r.Extensions["Cache"] != middleware.CacheStale {
+			t.Errorf("%d: got %v, expected stale result", i, r.Extensions["Cache"])
+		}
+		<-refreshed
+	}
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	store, ctx, entry := middleware.NewMemoryCacheStore(2), t.Context(), middleware.CacheEntry{Result: json.RawMessage(`1`)}
+
+	_ = store.Set(ctx, "a", entry, time.Minute)
+	_ = store.Set(ctx, "b", entry, time.Minute)
+	_, _ = store.Get(ctx, "a")
+	_ = store.Set(ctx, "c", entry, -time.Second)
+
+	// b is least recently used, c is expired
+	for key, cached := range map[string]bool{"a": true, "b": false, "c": false} {
+		if e, _ := store.Get(ctx, key); (e != nil) != cached {
+			t.Errorf("%s: got cached=%v, expected %v", key, e != nil, cached)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// CodeCircuitOpen is a JSON-RPC error code for requests rejected by open circuit breaker.
+const CodeCircuitOpen = http.StatusBadGateway
+
+// CircuitState is a circuit breaker state. Values are used in `app_rpc_circuit_breaker_state` metric.
+type CircuitState int
+
+const (
+	// CircuitClosed passes all requests.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests.
+	CircuitOpen
+	// CircuitHalfOpen passes limited number of probe requests.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitClosed:
+	}
+
+	return "closed"
+}
+
+// CircuitBreakerRule configures circuit breaker.
+type CircuitBreakerRule struct {
+	// Window is a period for failure rate calculation. Default is 10s.
+	Window time.Duration
+
+	// MinRequests is a min number of requests in window to open circuit. Default is 10.
+	MinRequests int
+
+	// FailureRate is a failure rate from 0 to 1 to open circuit. Default is 0.5.
+	FailureRate float64
+
+	// SlowCall is a duration after which successful call is counted as failure. Zero value disables latency check.
+	SlowCall time.Duration
+
+	// OpenTimeout is a time in open state before probes. Default is 30s.
+	OpenTimeout time.Duration
+
+	// Probes is a number of successful probe requests in half-open state to close circuit. Default is 1.
+	Probes int
+}
+
+// CircuitBreakerOptions configures WithCircuitBreaker.
+type CircuitBreakerOptions struct {
+	// Rules configure circuit breakers by `namespace.method`, `namespace` or `*` keys. The most specific rule is used.
+	// Every method has its own circuit breaker.
+	Rules map[string]CircuitBreakerRule
+
+	// IsFailure checks JSON-RPC error code. Default is IsServerError.
+	IsFailure func(code int) bool
+
+	// IsDevel or AllowDebugFunc enable `CircuitBreaker` field with state in response extensions.
+	IsDevel        bool
+	AllowDebugFunc AllowDebugFunc
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// IsServerError returns true for internal errors (500 or < 0) and CodeTimeout.
+func IsServerError(code int) bool {
+	return code == http.StatusInternalServerError || code < 0 || code == CodeTimeout
+}
+
+// WithCircuitBreaker rejects requests with CodeCircuitOpen error while failure rate of method exceeds FailureRate.
+// Failures are tracked per method, circuit breakers are created on first call of method.
+// After OpenTimeout circuit is half-opened: probe requests are passed and circuit is closed after successful probes
+// or is opened again after failed one. State is set to `CircuitBreaker` field in response extensions if debug is allowed.
+// It exposes metric `app_rpc_circuit_breaker_state` (0 – closed, 1 – open, 2 – half-open) with labels: breaker
+// (`namespace.method`), server.
+func WithCircuitBreaker(serverName string, opts CircuitBreakerOptions) zenrpc.MiddlewareFunc {
+	if serverName == "" {
+		serverName = "rpc"
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	if opts.IsFailure == nil {
+		opts.IsFailure = IsServerError
+	}
+
+	stateGauge := registerCollector(opts.Registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state by breaker: 0 – closed, 1 – open, 2 – half-open.",
+	}, []string{"breaker", "server"}))
+
+	rules := lowerKeys(opts.Rules)
+
+	var mu sync.Mutex
+	breakers := make(map[string]*circuitBreaker)
+
+	// breaker returns circuit breaker of method, it is created on first call
+	breaker := func(name string, rule CircuitBreakerRule) *circuitBreaker {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cb, ok := breakers[name]
+		if !ok {
+			cb = newCircuitBreaker(rule, stateGauge.WithLabelValues(name, serverName))
+			breakers[name] = cb
+		}
+
+		return cb
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			rule, ok := methodValue(rules, namespace, method)
+			if !ok {
+				return h(ctx, method, params)
+			}
+
+			name := namespace + "." + method
+			cb := breaker(name, rule)
+
+			var r zenrpc.Response
+			if probe, allowed := cb.allow(); !allowed {
+				r = zenrpc.NewResponseError(nil, CodeCircuitOpen, "Circuit breaker is open", nil)
+			} else {
+				r = cb.call(probe, opts.IsFailure, func() zenrpc.Response { return h(ctx, method, params) })
+			}
+
+			// unknown methods are not tracked
+			if r.Error != nil && r.Error.Code == zenrpc.MethodNotFound {
+				mu.Lock()
+				delete(breakers, name)
+				stateGauge.DeleteLabelValues(name, serverName)
+				mu.Unlock()
+
+				return r
+			}
+
+			if debugAllowed(ctx, opts.IsDevel, opts.AllowDebugFunc) {
+				if r.Extensions == nil {
+					r.Extensions = make(map[string]interface{})
+				}
+				r.Extensions["CircuitBreaker"] = cb.currentState().String()
+			}
+
+			return r
+		}
+	}
+}
+
+// circuitBreaker counts failures in fixed window.
+type circuitBreaker struct {
+	mu    sync.Mutex
+	rule  CircuitBreakerRule
+	state CircuitState
+	gauge prometheus.Gauge
+
+	windowStart    time.Time
+	total, failed  int
+	openedAt       time.Time
+	probesInFlight int
+	probesPassed   int
+}
+
+func newCircuitBreaker(rule CircuitBreakerRule, gauge prometheus.Gauge) *circuitBreaker {
+	if rule.Window <= 0 {
+		rule.Window = 10 * time.Second
+	}
+
+	if rule.MinRequests <= 0 {
+		rule.MinRequests = 10
+	}
+
+	if rule.FailureRate <= 0 || rule.FailureRate > 1 {
+		rule.FailureRate = 0.5
+	}
+
+	if rule.OpenTimeout <= 0 {
+		rule.OpenTimeout = 30 * time.Second
+	}
+
+	if rule.Probes <= 0 {
+		rule.Probes = 1
+	}
+
+	gauge.Set(float64(CircuitClosed))
+	return &circuitBreaker{rule: rule, gauge: gauge, windowStart: time.Now()}
+}
+
+// call executes fn and records its result. Panic is recorded as failure.
+func (cb *circuitBreaker) call(probe bool, isFailure func(int) bool, fn func() zenrpc.Response) zenrpc.Response {
+	start, failed := time.Now(), true
+	defer func() {
+		cb.record(probe, failed)
+	}()
+
+	r := fn()
+	failed = (r.Error != nil && isFailure(r.Error.Code)) || (cb.rule.SlowCall > 0 && time.Since(start) > cb.rule.SlowCall)
+
+	return r
+}
+
+// allow checks that request could be passed. Probe is true for requests in half-open state.
+func (cb *circuitBreaker) allow() (probe, allowed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.rule.OpenTimeout {
+		cb.setState(CircuitHalfOpen)
+	}
+
+	switch cb.state {
+	case CircuitOpen:
+		return false, false
+	case CircuitHalfOpen:
+		if cb.probesInFlight+cb.probesPassed >= cb.rule.Probes {
+			return false, false
+		}
+
+		cb.probesInFlight++
+		return true, true
+	case CircuitClosed:
+	}
+
+	return false, true
+}
+
+// record updates state by request result.
+func (cb *circuitBreaker) record(probe, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if probe {
+		cb.probesInFlight--
+		switch {
+		case cb.state != CircuitHalfOpen:
+		case failed:
+			cb.setState(CircuitOpen)
+		case cb.probesPassed+1 >= cb.rule.Probes:
+			cb.setState(CircuitClosed)
+		default:
+			cb.probesPassed++
+		}
+
+		return
+	}
+
+	// ignore results of requests started before circuit was opened
+	if cb.state != CircuitClosed {
+		return
+	}
+
+	if now := time.Now(); now.Sub(cb.windowStart) >= cb.rule.Window {
+		cb.windowStart, cb.total, cb.failed = now, 0, 0
+	}
+
+	cb.total++
+	if failed {
+		cb.failed++
+	}
+
+	if cb.total >= cb.rule.MinRequests && float64(cb.failed)/float64(cb.total) >= cb.rule.FailureRate {
+		cb.setState(CircuitOpen)
+	}
+}
+
+// setState changes state and resets counters. Mutex must be locked.
+func (cb *circuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.gauge.Set(float64(state))
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = time.Now()
+	case CircuitHalfOpen:
+		cb.probesPassed = 0
+	case CircuitClosed:
+		cb.windowStart, cb.total, cb.failed = time.Now(), 0, 0
+	}
+}
+
+func (cb *circuitBreaker) currentState() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state
+}
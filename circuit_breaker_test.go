@@ -0,0 +1,62 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	invoke := middleware.WithCircuitBreaker("test", middleware.CircuitBreakerOptions{
+		Rules:      map[string]middleware.CircuitBreakerRule{"*": {MinRequests: 2, OpenTimeout: 20 * time.Millisecond}},
+		IsDevel:    true,
+		Registerer: prometheus.NewRegistry(),
+	})(func(_ context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		if failing && method == "get" {
+			return zenrpc.NewResponseError(nil, http.StatusInternalServerError, "db is down", nil)
+		}
+
+		return zenrpc.Response{}
+	})
+
+	check := func(step string, code int, state string) {
+		t.Helper()
+
+		r := invoke(t.Context(), "get", nil)
+		if (r.Error == nil && code != 0) || (r.Error != nil && r.Error.Code != code) {
+			t.Errorf("%s: got error %+v, expected code %d", step, r.Error, code)
+		}
+
+		if r.Extensions["CircuitBreaker"] != state {
+			t.Errorf("%s: got state %v, expected %s", step, r.Extensions["CircuitBreaker"], state)
+		}
+	}
+
+	check("first failure", http.StatusInternalServerError, "closed")
+	check("second failure", http.StatusInternalServerError, "open")
+	check("open", middleware.CodeCircuitOpen, "open")
+
+	// other methods of the same rule have own circuit breakers
+	if r := invoke(t.Context(), "list", nil); r.Error != nil || r.Extensions["CircuitBreaker"] != "closed" {
+		t.Errorf("other method: got error %+v, state %v, expected closed", r.Error, r.Extensions["CircuitBreaker"])
+	}
+
+	// failed probe opens circuit again
+	time.Sleep(30 * time.Millisecond)
+	check("failed probe", http.StatusInternalServerError, "open")
+	check("reopened", middleware.CodeCircuitOpen, "open")
+
+	// successful probe closes circuit
+	time.Sleep(30 * time.Millisecond)
+	failing = false
+	check("successful probe", 0, "closed")
+	check("closed", 0, "closed")
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+const (
+	// CodeUpgradeRequired is a JSON-RPC error code for clients with version lower than required.
+	CodeUpgradeRequired = http.StatusUpgradeRequired
+
+	// CodeCountryBlocked is a JSON-RPC error code for requests from blocked countries.
+	CodeCountryBlocked = http.StatusUnavailableForLegalReasons
+)
+
+//nolint:gochecknoglobals // compiled once
+var semverRe = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// ClientPolicy is a set of restrictions by client country, platform and version from context (see WithHeaders).
+type ClientPolicy struct {
+	// BlockCountries are blocked countries.
+	BlockCountries []string
+
+	// AllowCountries are the only allowed countries if set. Requests without country are allowed.
+	AllowCountries []string
+
+	// RouteCountries are methods of the same namespace to call instead of requested one by country.
+	RouteCountries map[string]string
+
+	// MinVersions are min semver versions by platform, e.g. {"ios": "5.2.0"} means ios>=5.2.0.
+	// Pre-release and build parts of versions are ignored.
+	MinVersions map[string]string
+}
+
+// UpgradeRequiredData is a data of CodeUpgradeRequired error.
+type UpgradeRequiredData struct {
+	Platform   string `json:"platform"`
+	Version    string `json:"version"`
+	MinVersion string `json:"minVersion"`
+}
+
+// CountryBlockedData is a data of CodeCountryBlocked error.
+type CountryBlockedData struct {
+	Country string `json:"country"`
+}
+
+// clientPolicy is a ClientPolicy with parsed versions by lower case platform.
+type clientPolicy struct {
+	ClientPolicy
+	minVersions map[string]minVersion
+}
+
+// minVersion is a parsed min version.
+type minVersion struct {
+	version semver
+	value   string
+}
+
+// semver is a major, minor and patch version.
+type semver [3]int
+
+// WithClientPolicy applies ClientPolicy by `namespace.method`, `namespace` or `*` keys. The most specific policy is used.
+// Requests from blocked countries get CodeCountryBlocked error, clients with version lower than required get
+// CodeUpgradeRequired error with UpgradeRequiredData. Platforms and countries are case-insensitive.
+// It panics if min version is not a valid semver. WithHeaders must be used before WithClientPolicy.
+func WithClientPolicy(policies map[string]ClientPolicy) zenrpc.MiddlewareFunc {
+	parsed := make(map[string]clientPolicy, len(policies))
+	for key, p := range policies {
+		cp := clientPolicy{ClientPolicy: p, minVersions: make(map[string]minVersion, len(p.MinVersions))}
+		for platform, v := range p.MinVersions {
+			sv, ok := parseSemver(v)
+			if !ok {
+				panic(fmt.Sprintf("middleware: invalid min version %q for %s in %q policy", v, platform, key))
+			}
+
+			cp.minVersions[strings.ToLower(platform)] = minVersion{version: sv, value: v}
+		}
+
+		parsed[strings.ToLower(key)] = cp
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			p, ok := methodValue(parsed, zenrpc.NamespaceFromContext(ctx), method)
+			if !ok {
+				return h(ctx, method, params)
+			}
+
+			if country := strings.ToUpper(appkit.CountryFromContext(ctx)); country != "" {
+				if containsFold(p.BlockCountries, country) || (len(p.AllowCountries) > 0 && !containsFold(p.AllowCountries, country)) {
+					return zenrpc.NewResponseError(nil, CodeCountryBlocked, "Country blocked", CountryBlockedData{Country: country})
+				}
+
+				for c, route := range p.RouteCountries {
+					if strings.EqualFold(c, country) {
+						method = route
+						break
+					}
+				}
+			}
+
+			platform, version := strings.ToLower(appkit.PlatformFromContext(ctx)), appkit.VersionFromContext(ctx)
+			if mv, ok := p.minVersions[platform]; ok {
+				if v, ok := parseSemver(version); !ok || v.less(mv.version) {
+					return zenrpc.NewResponseError(nil, CodeUpgradeRequired, "Upgrade required", UpgradeRequiredData{
+						Platform:   platform,
+						Version:    version,
+						MinVersion: mv.value,
+					})
+				}
+			}
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// parseSemver parses version like v1.2.3, 1.2 or 1.
+func parseSemver(v string) (semver, bool) {
+	m := semverRe.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return semver{}, false
+	}
+
+	var sv semver
+	for i := range sv {
+		sv[i], _ = strconv.Atoi(m[i+1])
+	}
+
+	return sv, true
+}
+
+func (v semver) less(other semver) bool {
+	return slices.Compare(v[:], other[:]) < 0
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
@@ -0,0 +1,68 @@
+package middleware_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareClientPolicy(t *testing.T) {
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithHeaders(),
+		middleware.WithClientPolicy(map[string]middleware.ClientPolicy{
+			"arith": {
+				BlockCountries: []string{"xx"},
+				RouteCountries: map[string]string{"DE": "multiply"},
+				MinVersions:    map[string]string{"iOS": "5.2.0"},
+			},
+		}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	tc := []struct {
+		country, platform, version, out string
+	}{
+		{"", "", "", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"XX", "", "", `{"jsonrpc":"2.0","id":1,"error":{"code":451,"message":"Country blocked","data":{"country":"XX"}}}`},
+		{"de", "", "", `{"jsonrpc":"2.0","id":1,"result":2}`},
+		{"", "ios", "5.1.9", `{"jsonrpc":"2.0","id":1,"error":{"code":426,"message":"Upgrade required","data":{"platform":"ios","version":"5.1.9","minVersion":"5.2.0"}}}`},
+		{"", "ios", "", `{"jsonrpc":"2.0","id":1,"error":{"code":426,"message":"Upgrade required","data":{"platform":"ios","version":"","minVersion":"5.2.0"}}}`},
+		{"", "ios", "v5.10", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"", "android", "1.0.0", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+	}
+
+	for i, c := range tc {
+		in := `{"jsonrpc": "2.0", "method": "arith.divide", "params": { "a": 1, "b": 2 }, "id": 1 }`
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(in))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Country", c.country)
+		req.Header.Set("Platform", c.platform)
+		req.Header.Set("Version", c.version)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(resp) != c.out {
+			t.Errorf("%d: got %s expected %s", i, resp, c.out)
+		}
+	}
+}
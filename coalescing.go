@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/zenrpc/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalescingOptions configures WithCoalescing.
+type CoalescingOptions struct {
+	// Methods are `namespace.method`, `namespace` or `*` for coalescing.
+	Methods []string
+
+	// VaryPlatform, VaryVersion and VaryCountry add platform, version and country from context to call key.
+	VaryPlatform bool
+	VaryVersion  bool
+	VaryCountry  bool
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// WithCoalescing deduplicates concurrent calls of the same method with the same canonical params: only one call is
+// executed and all waiting calls get a copy of its response. Context of the first call is used for execution.
+// It exposes metric `app_rpc_coalesced_requests_total` with labels: method, server.
+func WithCoalescing(serverName string, opts CoalescingOptions) zenrpc.MiddlewareFunc {
+	if serverName == "" {
+		serverName = "rpc"
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	coalesced := registerCollector(opts.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "coalesced_requests_total",
+		Help:      "Requests count served by concurrent call of the same method with the same params.",
+	}, []string{"method", "server"}))
+
+	methods := make(map[string]bool, len(opts.Methods))
+	for _, m := range opts.Methods {
+		methods[strings.ToLower(m)] = true
+	}
+
+	rule := CacheRule{VaryPlatform: opts.VaryPlatform, VaryVersion: opts.VaryVersion, VaryCountry: opts.VaryCountry}
+
+	var group singleflight.Group
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			if _, ok := methodKey(methods, namespace, method); !ok {
+				return h(ctx, method, params)
+			}
+
+			leader := false
+			v, _, shared := group.Do(cacheKey(ctx, namespace, method, params, rule), func() (any, error) {
+				leader = true
+				return h(ctx, method, params), nil
+			})
+
+			r := v.(zenrpc.Response) //nolint:errcheck // always zenrpc.Response
+			if !shared {
+				return r
+			}
+
+			if !leader {
+				coalesced.WithLabelValues(namespace+"."+method, serverName).Inc()
+			}
+
+			return copyResponse(r)
+		}
+	}
+}
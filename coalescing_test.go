@@ -0,0 +1,101 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// waitCoalesced waits until n calls are waiting for result of concurrent call with the same key.
+func waitCoalesced(t *testing.T, n int) {
+	t.Helper()
+
+	buf := make([]byte, 1<<20)
+	deadline := time.After(5 * time.Second)
+	for {
+		waiting := 0
+		for _, g := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+			if strings.Contains(g, "sync.(*WaitGroup).Wait") && strings.Contains(g, "singleflight.(*Group).Do") {
+				waiting++
+			}
+		}
+
+		if waiting == n {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("got %d coalesced calls, expected %d", waiting, n)
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	entered, block := make(chan struct{}, 2), make(chan struct{})
+
+	var calls atomic.Int32
+	h := middleware.WithCoalescing("test", middleware.CoalescingOptions{
+		Methods:    []string{"*"},
+		Registerer: reg,
+	})(func(_ context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		calls.Add(1)
+		entered <- struct{}{}
+		<-block
+		return zenrpc.Response{Extensions: map[string]interface{}{"method": method}}
+	})
+
+	var wg sync.WaitGroup
+	responses := make(chan zenrpc.Response, 3)
+	call := func(params string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses <- h(t.Context(), "get", json.RawMessage(params))
+		}()
+	}
+
+	// calls with different params are started, call with the same params waits for the first one
+	call(`{"a":1,"b":2}`)
+	call(`{"a":2}`)
+	<-entered
+	<-entered
+
+	call(`{"b": 2, "a": 1}`)
+	waitCoalesced(t, 1)
+
+	close(block)
+	wg.Wait()
+	close(responses)
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d calls, expected 2", n)
+	}
+
+	for r := range responses {
+		r.Extensions["changed"] = true
+	}
+
+	expected := `
+# HELP app_rpc_coalesced_requests_total Requests count served by concurrent call of the same method with the same params.
+# TYPE app_rpc_coalesced_requests_total counter
+app_rpc_coalesced_requests_total{method=".get",server="test"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_coalesced_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// CodeOverloaded is a JSON-RPC error code for requests rejected by concurrency limiter.
+const CodeOverloaded = http.StatusServiceUnavailable
+
+const globalLimiter = "global"
+
+// ConcurrencyOptions configures WithConcurrencyLimit.
+type ConcurrencyOptions struct {
+	// Global is a max number of concurrent requests for all methods. Zero value means unlimited.
+	Global int
+
+	// Methods are max numbers of concurrent requests by `namespace.method`, `namespace` or `*` keys.
+	// The most specific limit is used. Methods of namespace share one limit if it is set for namespace.
+	// Zero limit means unlimited, e.g. to exclude method from namespace limit.
+	Methods map[string]int
+
+	// QueueTimeout is a max time to wait for free slot. Zero value means requests are rejected immediately.
+	QueueTimeout time.Duration
+
+	// MaxQueue is a max number of waiting requests per limit. Zero value means unlimited.
+	MaxQueue int
+
+	// Adaptive enables adaptive limits, configured limits are used as max limits.
+	Adaptive *AdaptiveLimit
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// AdaptiveLimit configures AIMD limit: limit is increased by one per limit requests faster than LatencyTarget
+// and is multiplied by Backoff for slower requests.
+type AdaptiveLimit struct {
+	// LatencyTarget is a max latency for limit increasing.
+	LatencyTarget time.Duration
+
+	// MinLimit is a min limit. Default is 1.
+	MinLimit int
+
+	// Backoff is a multiplier for limit decreasing. Default is 0.9.
+	Backoff float64
+}
+
+// WithConcurrencyLimit limits concurrent requests globally and per method. Requests wait for free slot QueueTimeout
+// in FIFO order, and are rejected with CodeOverloaded error if queue is full or timeout is exceeded.
+// It exposes metrics: `app_rpc_concurrency_limit`, `app_rpc_concurrency_queue` with labels: limiter, server
+// and `app_rpc_concurrency_rejected_total` with labels: limiter, reason (limit, queue_full, timeout), server.
+func WithConcurrencyLimit(serverName string, opts ConcurrencyOptions) zenrpc.MiddlewareFunc {
+	if serverName == "" {
+		serverName = "rpc"
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	limitGauge := registerCollector(opts.Registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "concurrency_limit",
+		Help:      "Current concurrency limit by limiter.",
+	}, []string{"limiter", "server"}))
+	queueGauge := registerCollector(opts.Registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "concurrency_queue",
+		Help:      "Current number of requests waiting for free slot by limiter.",
+	}, []string{"limiter", "server"}))
+	rejected := registerCollector(opts.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "concurrency_rejected_total",
+		Help:      "Rejected requests count by limiter and reason.",
+	}, []string{"limiter", "reason", "server"}))
+
+	newLimiter := func(name string, limit int) *concurrencyLimiter {
+		return newConcurrencyLimiter(limit, opts, limitGauge.WithLabelValues(name, serverName), queueGauge.WithLabelValues(name, serverName), func(reason string) {
+			rejected.WithLabelValues(name, reason, serverName).Inc()
+		})
+	}
+
+	var global *concurrencyLimiter
+	if opts.Global > 0 {
+		global = newLimiter(globalLimiter, opts.Global)
+	}
+
+	limiters := make(map[string]*concurrencyLimiter, len(opts.Methods))
+	for key, limit := range opts.Methods {
+		key = strings.ToLower(key)
+		if limit <= 0 {
+			// keep key to override less specific limit
+			limiters[key] = nil
+			continue
+		}
+
+		limiters[key] = newLimiter(key, limit)
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			ml, _ := methodValue(limiters, zenrpc.NamespaceFromContext(ctx), method)
+			if ml != nil && !ml.acquire(ctx) {
+				return zenrpc.NewResponseError(nil, CodeOverloaded, "Server overloaded", nil)
+			}
+
+			if global != nil && !global.acquire(ctx) {
+				if ml != nil {
+					ml.release(0)
+				}
+
+				return zenrpc.NewResponseError(nil, CodeOverloaded, "Server overloaded", nil)
+			}
+
+			start := time.Now()
+			defer func() {
+				d := time.Since(start)
+				if global != nil {
+					global.release(d)
+				}
+
+				if ml != nil {
+					ml.release(d)
+				}
+			}()
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// concurrencyLimiter is a semaphore with queue and adaptive limit.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	maxLimit float64
+	inFlight int
+	waiters  []chan struct{}
+
+	queueTimeout time.Duration
+	maxQueue     int
+	adaptive     *AdaptiveLimit
+
+	gauge      prometheus.Gauge
+	queueGauge prometheus.Gauge
+	reject     func(reason string)
+}
+
+func newConcurrencyLimiter(limit int, opts ConcurrencyOptions, gauge, queueGauge prometheus.Gauge, reject func(reason string)) *concurrencyLimiter {
+	cl := &concurrencyLimiter{
+		limit:        float64(limit),
+		maxLimit:     float64(limit),
+		queueTimeout: opts.QueueTimeout,
+		maxQueue:     opts.MaxQueue,
+		gauge:        gauge,
+		queueGauge:   queueGauge,
+		reject:       reject,
+	}
+
+	if opts.Adaptive != nil {
+		a := *opts.Adaptive
+		if a.MinLimit <= 0 {
+			a.MinLimit = 1
+		}
+
+		if a.Backoff <= 0 || a.Backoff >= 1 {
+			a.Backoff = 0.9
+		}
+
+		cl.adaptive = &a
+	}
+
+	gauge.Set(cl.limit)
+	return cl
+}
+
+// acquire takes slot or waits for it in queue. Slots are taken in FIFO order.
+func (cl *concurrencyLimiter) acquire(ctx context.Context) bool {
+	cl.mu.Lock()
+	if len(cl.waiters) == 0 && cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		cl.mu.Unlock()
+		return true
+	}
+
+	if cl.queueTimeout <= 0 {
+		cl.mu.Unlock()
+		cl.reject("limit")
+		return false
+	}
+
+	if cl.maxQueue > 0 && len(cl.waiters) >= cl.maxQueue {
+		cl.mu.Unlock()
+		cl.reject("queue_full")
+		return false
+	}
+
+	ch := make(chan struct{})
+	cl.waiters = append(cl.waiters, ch)
+	cl.queueGauge.Set(float64(len(cl.waiters)))
+	cl.mu.Unlock()
+
+	timer := time.NewTimer(cl.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for i := range cl.waiters {
+		if cl.waiters[i] == ch {
+			cl.waiters = append(cl.waiters[:i], cl.waiters[i+1:]...)
+			cl.queueGauge.Set(float64(len(cl.waiters)))
+			cl.reject("timeout")
+			return false
+		}
+	}
+
+	// slot was handed over concurrently
+	return true
+}
+
+// release frees slot and adapts limit by request duration. Zero duration skips adaptation.
+func (cl *concurrencyLimiter) release(d time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.adaptive != nil && d > 0 {
+		if d <= cl.adaptive.LatencyTarget {
+			cl.limit = math.Min(cl.maxLimit, cl.limit+1/cl.limit)
+		} else {
+			cl.limit = math.Max(float64(cl.adaptive.MinLimit), cl.limit*cl.adaptive.Backoff)
+		}
+		cl.gauge.Set(math.Floor(cl.limit))
+	}
+
+	cl.inFlight--
+	cl.dispatch()
+}
+
+// dispatch hands over free slots to waiters in FIFO order, e.g. after release or limit growth. Mutex must be locked.
+func (cl *concurrencyLimiter) dispatch() {
+	if len(cl.waiters) == 0 {
+		return
+	}
+
+	for len(cl.waiters) > 0 && cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		close(cl.waiters[0])
+		cl.waiters = cl.waiters[1:]
+	}
+
+	cl.queueGauge.Set(float64(len(cl.waiters)))
+}
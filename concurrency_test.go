@@ -0,0 +1,192 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// waitQueued waits until n requests are waiting in concurrency limiter queue.
+func waitQueued(t *testing.T, reg *prometheus.Registry, n float64) {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var queued float64
+		for _, mf := range mfs {
+			if mf.GetName() == "app_rpc_concurrency_queue" {
+				for _, m := range mf.GetMetric() {
+					queued += m.GetGauge().GetValue()
+				}
+			}
+		}
+
+		if queued == n {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("got %v queued requests, expected %v", queued, n)
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	started, block := make(chan string, 3), make(chan struct{})
+	h := middleware.WithConcurrencyLimit("concurrency", middleware.ConcurrencyOptions{
+		Methods:      map[string]int{"*": 1},
+		QueueTimeout: time.Minute,
+		MaxQueue:     2,
+		Registerer:   reg,
+	})(func(_ context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		started <- method
+		<-block
+		return zenrpc.Response{}
+	})
+
+	done := make(chan zenrpc.Response, 4)
+	go func() { done <- h(t.Context(), "first", nil) }()
+	<-started
+
+	// second and third requests wait in queue, fourth is rejected immediately
+	ctx, cancel := context.WithCancel(t.Context())
+	go func() { done <- h(ctx, "second", nil) }()
+	waitQueued(t, reg, 1)
+	go func() { done <- h(t.Context(), "third", nil) }()
+	waitQueued(t, reg, 2)
+
+	if r := h(t.Context(), "fourth", nil); r.Error == nil || r.Error.Code != middleware.CodeOverloaded {
+		t.Fatalf("got %+v, expected overloaded error", r.Error)
+	}
+
+	// canceled request leaves queue
+	cancel()
+	if r := <-done; r.Error == nil || r.Error.Code != middleware.CodeOverloaded {
+		t.Fatalf("got %+v, expected overloaded error", r.Error)
+	}
+
+	go func() { done <- h(t.Context(), "fifth", nil) }()
+	waitQueued(t, reg, 2)
+
+	// slots are handed over to queued requests in FIFO order
+	for _, next := range []string{"third", "fifth"} {
+		block <- struct{}{}
+		if method := <-started; method != next {
+			t.Errorf("got %s started, expected %s", method, next)
+		}
+	}
+	block <- struct{}{}
+
+	for range 3 {
+		if r := <-done; r.Error != nil {
+			t.Errorf("got error %+v", r.Error)
+		}
+	}
+}
+
+func TestConcurrencyLimitZero(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	started, block := make(chan struct{}), make(chan struct{})
+	h := middleware.WithConcurrencyLimit("concurrency", middleware.ConcurrencyOptions{
+		Methods:    map[string]int{"*": 1, ".unlimited": 0},
+		Registerer: reg,
+	})(func(context.Context, string, json.RawMessage) zenrpc.Response {
+		started <- struct{}{}
+		<-block
+		return zenrpc.Response{}
+	})
+
+	done := make(chan zenrpc.Response, 3)
+	for _, method := range []string{"limited", "unlimited", "unlimited"} {
+		go func() { done <- h(t.Context(), method, nil) }()
+		<-started
+	}
+
+	// limited method is rejected immediately without queue
+	if r := h(t.Context(), "limited", nil); r.Error == nil || r.Error.Code != middleware.CodeOverloaded {
+		t.Fatalf("got %+v, expected overloaded error", r.Error)
+	}
+
+	close(block)
+	for range 3 {
+		if r := <-done; r.Error != nil {
+			t.Errorf("got error %+v", r.Error)
+		}
+	}
+
+	expected := `
+# HELP app_rpc_concurrency_rejected_total Rejected requests count by limiter and reason.
+# TYPE app_rpc_concurrency_rejected_total counter
+app_rpc_concurrency_rejected_total{limiter="*",reason="limit",server="concurrency"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_concurrency_rejected_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConcurrencyLimitAdaptive(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	started, block := make(chan string, 2), make(chan struct{})
+	h := middleware.WithConcurrencyLimit("concurrency", middleware.ConcurrencyOptions{
+		Methods:      map[string]int{"*": 2},
+		QueueTimeout: time.Minute,
+		Adaptive:     &middleware.AdaptiveLimit{LatencyTarget: 100 * time.Millisecond, Backoff: 0.5},
+		Registerer:   reg,
+	})(func(_ context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		if method == "slow" {
+			time.Sleep(150 * time.Millisecond)
+			return zenrpc.Response{}
+		}
+
+		started <- method
+		<-block
+		return zenrpc.Response{}
+	})
+
+	// slow request decreases limit to 1, requests after fast one are queued
+	if r := h(t.Context(), "slow", nil); r.Error != nil {
+		t.Fatalf("got error %+v", r.Error)
+	}
+
+	done := make(chan zenrpc.Response, 3)
+	go func() { done <- h(t.Context(), "fast", nil) }()
+	<-started
+
+	go func() { done <- h(t.Context(), "first", nil) }()
+	waitQueued(t, reg, 1)
+	go func() { done <- h(t.Context(), "second", nil) }()
+	waitQueued(t, reg, 2)
+
+	// fast request increases limit to 2, both queued requests are woken
+	block <- struct{}{}
+	woken := map[string]bool{<-started: true, <-started: true}
+	if !woken["first"] || !woken["second"] {
+		t.Errorf("got %v started, expected first and second", woken)
+	}
+	waitQueued(t, reg, 0)
+
+	close(block)
+	for range 3 {
+		if r := <-done; r.Error != nil {
+			t.Errorf("got error %+v", r.Error)
+		}
+	}
+}
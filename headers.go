@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// Default header limits.
+const (
+	DefaultMaxUserAgent  = 2048
+	DefaultMaxPlatform   = 64
+	DefaultMaxVersion    = 64
+	DefaultMaxCountry    = 16
+	DefaultMaxXRequestID = 256
+	DefaultMaxValue      = 256
+)
+
+// HeaderExtractor sets header value to context. It is created by NewHeaderValue.
+type HeaderExtractor interface {
+	// Header returns header name.
+	Header() string
+
+	newContext(ctx context.Context, value string) context.Context
+}
+
+// HeaderValue is a typed header value in context.
+type HeaderValue[T any] struct {
+	header string
+	parse  func(string) (T, error)
+}
+
+type headerValueKey struct {
+	header string
+}
+
+// NewHeaderValue returns typed header value. Values with parse errors are not set to context.
+//
+//	build := middleware.NewHeaderValue("X-App-Build", strconv.Atoi)
+//	n, ok := build.FromContext(ctx)
+func NewHeaderValue[T any](header string, parse func(string) (T, error)) *HeaderValue[T] {
+	return &HeaderValue[T]{header: http.CanonicalHeaderKey(header), parse: parse}
+}
+
+// StringHeader returns string header value.
+func StringHeader(header string) *HeaderValue[string] {
+	return NewHeaderValue(header, func(s string) (string, error) { return s, nil })
+}
+
+// Header returns header name.
+func (hv *HeaderValue[T]) Header() string {
+	return hv.header
+}
+
+// FromContext returns header value from context.
+func (hv *HeaderValue[T]) FromContext(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(headerValueKey{header: hv.header}).(T)
+	return v, ok
+}
+
+func (hv *HeaderValue[T]) newContext(ctx context.Context, value string) context.Context {
+	v, err := hv.parse(value)
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, headerValueKey{header: hv.header}, v)
+}
+
+// HeadersOptions configures WithHeadersOpts. Zero limits are replaced with defaults.
+type HeadersOptions struct {
+	// Max lengths in runes for User-Agent, Platform, Version, X-Country and X-Request-ID headers.
+	MaxUserAgent  int
+	MaxPlatform   int
+	MaxVersion    int
+	MaxCountry    int
+	MaxXRequestID int
+
+	// Values are additional headers set to context, e.g. StringHeader("X-Device-Id").
+	Values []HeaderExtractor
+
+	// MaxValue is a max length in runes for Values.
+	MaxValue int
+
+	// GenerateXRequestID sets random X-Request-ID to context if it is not sent by client.
+	GenerateXRequestID bool
+}
+
+// WithHeadersOpts is the same as WithHeaders, but with configurable limits and additional headers.
+// Non-printable characters are removed from all header values.
+// It also sets empty Principal slot to context, which is filled by auth middlewares (e.g. WithAPIKeyAuth).
+func WithHeadersOpts(opts HeadersOptions) zenrpc.MiddlewareFunc {
+	opts.MaxUserAgent = defaultLimit(opts.MaxUserAgent, DefaultMaxUserAgent)
+	opts.MaxPlatform = defaultLimit(opts.MaxPlatform, DefaultMaxPlatform)
+	opts.MaxVersion = defaultLimit(opts.MaxVersion, DefaultMaxVersion)
+	opts.MaxCountry = defaultLimit(opts.MaxCountry, DefaultMaxCountry)
+	opts.MaxXRequestID = defaultLimit(opts.MaxXRequestID, DefaultMaxXRequestID)
+	opts.MaxValue = defaultLimit(opts.MaxValue, DefaultMaxValue)
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			ctx = newPrincipalHolderContext(ctx)
+			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+				ctx = appkit.NewUserAgentContext(ctx, sanitizeHeader(req.UserAgent(), opts.MaxUserAgent))
+				ctx = appkit.NewPlatformContext(ctx, sanitizeHeader(req.Header.Get("Platform"), opts.MaxPlatform))
+				ctx = appkit.NewVersionContext(ctx, sanitizeHeader(req.Header.Get("Version"), opts.MaxVersion))
+				ctx = appkit.NewCountryContext(ctx, sanitizeHeader(req.Header.Get("X-Country"), opts.MaxCountry))
+				ctx = appkit.NewMethodContext(ctx, method)
+
+				xRequestID := sanitizeHeader(req.Header.Get(echo.HeaderXRequestID), opts.MaxXRequestID)
+				if xRequestID == "" && opts.GenerateXRequestID {
+					xRequestID = newXRequestID()
+				}
+				ctx = appkit.NewXRequestIDContext(ctx, xRequestID)
+
+				for _, v := range opts.Values {
+					if value := sanitizeHeader(req.Header.Get(v.Header()), opts.MaxValue); value != "" {
+						ctx = v.newContext(ctx, value)
+					}
+				}
+			}
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// sanitizeHeader removes invalid UTF-8 and non-printable characters and truncates value to maxLen runes.
+func sanitizeHeader(value string, maxLen int) string {
+	clean := true
+	for _, r := range value {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			clean = false
+			break
+		}
+	}
+
+	if clean && len(value) <= maxLen {
+		return value
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range value {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			continue
+		}
+
+		if n == maxLen {
+			break
+		}
+
+		b.WriteRune(r)
+		n++
+	}
+
+	return b.String()
+}
+
+// newXRequestID returns random 16 bytes in hex.
+func newXRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func defaultLimit(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+
+	return v
+}
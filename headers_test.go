@@ -0,0 +1,76 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareHeadersOpts(t *testing.T) {
+	build, device := middleware.NewHeaderValue("X-App-Build", strconv.Atoi), middleware.StringHeader("X-Device-Id")
+
+	var (
+		platform, xRequestID, deviceID string
+		buildNumber                    int
+		hasBuild                       bool
+	)
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithHeadersOpts(middleware.HeadersOptions{
+			MaxPlatform:        8,
+			Values:             []middleware.HeaderExtractor{build, device},
+			GenerateXRequestID: true,
+		}),
+		func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+			return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+				platform, xRequestID = appkit.PlatformFromContext(ctx), appkit.XRequestIDFromContext(ctx)
+				buildNumber, hasBuild = build.FromContext(ctx)
+				deviceID, _ = device.FromContext(ctx)
+				return h(ctx, method, params)
+			}
+		},
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	do := func(header map[string]string) {
+		in := `{"jsonrpc": "2.0", "method": "arith.pi", "id": 1 }`
+		// header values are not validated by httptest.NewRequest
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(in))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		rpc.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	do(map[string]string{"Platform": "Андроид\x7f-phone", "X-App-Build": "42", "X-Device-Id": "device\x01"})
+	if platform != "Андроид-" {
+		t.Errorf("got platform %q", platform)
+	}
+
+	if !hasBuild || buildNumber != 42 || deviceID != "device" {
+		t.Errorf("got build=%d,%v device=%q", buildNumber, hasBuild, deviceID)
+	}
+
+	if len(xRequestID) != 32 {
+		t.Errorf("got generated xRequestId %q", xRequestID)
+	}
+
+	do(map[string]string{"X-App-Build": "beta", "X-Request-Id": strings.Repeat("a", 300)})
+	if hasBuild || len(xRequestID) != middleware.DefaultMaxXRequestID {
+		t.Errorf("got build=%v xRequestId length %d", hasBuild, len(xRequestID))
+	}
+}
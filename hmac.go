@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// HMAC signature headers.
+const (
+	HeaderSignature          = "X-Signature"
+	HeaderSignatureKeyID     = "X-Signature-Key-Id"
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+	HeaderSignatureNonce     = "X-Signature-Nonce"
+)
+
+// DefaultSignatureWindow is a default max difference between signature timestamp and server time.
+const DefaultSignatureWindow = 5 * time.Minute
+
+// NonceStore stores used nonces. Implementation must be safe for concurrent use.
+type NonceStore interface {
+	// Add saves nonce for ttl. It returns false if nonce was already used.
+	Add(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// HMACOptions configures WithHMACAuth.
+type HMACOptions struct {
+	// Keys are shared secrets by key id.
+	Keys map[string][]byte
+
+	// Window is a max difference between signature timestamp and server time. Default is DefaultSignatureWindow.
+	Window time.Duration
+
+	// Nonces is a store for replay protection. Default is NewMemoryNonceStore().
+	Nonces NonceStore
+}
+
+// WithHMACAuth verifies HMAC-SHA256 signature of method, params and timestamp from X-Signature* headers
+// (see HMACSignature). Requests with invalid signature, timestamp out of window or already used nonce are rejected
+// with CodeUnauthorized error. Key id is set to context as Principal ID. Batch requests are not supported.
+// Requests could be signed with NewHMACRoundTripper.
+func WithHMACAuth(opts HMACOptions) zenrpc.MiddlewareFunc {
+	if opts.Window <= 0 {
+		opts.Window = DefaultSignatureWindow
+	}
+
+	if opts.Nonces == nil {
+		opts.Nonces = NewMemoryNonceStore()
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			req, ok := zenrpc.RequestFromContext(ctx)
+			if !ok || req == nil {
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Invalid signature", nil)
+			}
+
+			keyID, ts, nonce := req.Header.Get(HeaderSignatureKeyID), req.Header.Get(HeaderSignatureTimestamp), req.Header.Get(HeaderSignatureNonce)
+			secret, ok := opts.Keys[keyID]
+			if !ok || nonce == "" {
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Invalid signature", nil)
+			}
+
+			unix, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil || time.Since(time.Unix(unix, 0)).Abs() > opts.Window {
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Invalid signature", nil)
+			}
+
+			fullMethod := method
+			if namespace := zenrpc.NamespaceFromContext(ctx); namespace != "" {
+				fullMethod = namespace + "." + method
+			}
+
+			expected := HMACSignature(secret, ts, nonce, fullMethod, params)
+			if !hmac.Equal([]byte(expected), []byte(req.Header.Get(HeaderSignature))) {
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Invalid signature", nil)
+			}
+
+			// nonce must be kept while timestamp is in window
+			added, err := opts.Nonces.Add(ctx, keyID+"|"+nonce, 2*opts.Window)
+			if err != nil {
+				r := zenrpc.NewResponseError(nil, http.StatusInternalServerError, "Internal error", nil)
+				r.Error.Err = err
+				return r
+			}
+
+			if !added {
+				return zenrpc.NewResponseError(nil, CodeUnauthorized, "Invalid signature", nil)
+			}
+
+			return h(NewPrincipalContext(ctx, &Principal{ID: keyID}), method, params)
+		}
+	}
+}
+
+// HMACSignature returns hex encoded HMAC-SHA256 of timestamp, nonce, method in `namespace.method` format and params
+// separated by new line. Method is case-insensitive.
+func HMACSignature(secret []byte, timestamp, nonce, method string, params json.RawMessage) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + strings.ToLower(method) + "\n"))
+	mac.Write(params)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hmacRoundTripper signs JSON-RPC requests.
+type hmacRoundTripper struct {
+	keyID  string
+	secret []byte
+	next   http.RoundTripper
+}
+
+// NewHMACRoundTripper returns http.RoundTripper that signs JSON-RPC requests for WithHMACAuth.
+// If next is nil, http.DefaultTransport is used.
+//
+//	client := &http.Client{Transport: middleware.NewHMACRoundTripper("billing", secret, nil)}
+func NewHMACRoundTripper(keyID string, secret []byte, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &hmacRoundTripper{keyID: keyID, secret: secret, next: next}
+}
+
+func (rt *hmacRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return rt.next.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return nil, errors.New("hmac: batch requests are not supported")
+	}
+
+	var call struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err = json.Unmarshal(body, &call); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(b)
+
+	// RoundTripper must not modify original request
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set(HeaderSignatureKeyID, rt.keyID)
+	req.Header.Set(HeaderSignatureTimestamp, ts)
+	req.Header.Set(HeaderSignatureNonce, nonce)
+	req.Header.Set(HeaderSignature, HMACSignature(rt.secret, ts, nonce, call.Method, call.Params))
+
+	return rt.next.RoundTrip(req)
+}
+
+// memoryNonceStore is an in-memory NonceStore.
+type memoryNonceStore struct {
+	nonces      map[string]time.Time
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+// NewMemoryNonceStore returns in-memory NonceStore. Expired nonces are removed periodically.
+func NewMemoryNonceStore() *memoryNonceStore {
+	return &memoryNonceStore{
+		nonces:      make(map[string]time.Time),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *memoryNonceStore) Add(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// remove expired nonces once a minute
+	if now.Sub(s.lastCleanup) >= time.Minute {
+		s.lastCleanup = now
+		for key, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, key)
+			}
+		}
+	}
+
+	if expires, ok := s.nonces[nonce]; ok && now.Before(expires) {
+		return false, nil
+	}
+
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
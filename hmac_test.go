@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+type errNonceStore struct{}
+
+func (errNonceStore) Add(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("dial tcp 10.0.0.1:6379: connection refused")
+}
+
+func TestMiddlewareHMACAuth(t *testing.T) {
+	secret := []byte("secret")
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(middleware.WithHMACAuth(middleware.HMACOptions{Keys: map[string][]byte{"billing": secret}}))
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	in := `{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 2 }, "id": 1 }`
+	ok := `{"jsonrpc":"2.0","id":1,"result":2}`
+	invalid := `{"jsonrpc":"2.0","id":1,"error":{"code":401,"message":"Invalid signature"}}`
+
+	do := func(client *http.Client, header http.Header) string {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(in))
+		req.Header = header.Clone()
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(resp)
+	}
+
+	// signed by client
+	client := &http.Client{Transport: middleware.NewHMACRoundTripper("billing", secret, nil)}
+	for range 2 {
+		if out := do(client, http.Header{}); out != ok {
+			t.Errorf("got %s expected %s", out, ok)
+		}
+	}
+
+	if out := do(&http.Client{Transport: middleware.NewHMACRoundTripper("billing", []byte("wrong"), nil)}, http.Header{}); out != invalid {
+		t.Errorf("wrong secret: got %s expected %s", out, invalid)
+	}
+
+	if out := do(http.DefaultClient, http.Header{}); out != invalid {
+		t.Errorf("unsigned: got %s expected %s", out, invalid)
+	}
+
+	// replay and expired timestamp
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set(middleware.HeaderSignatureKeyID, "billing")
+	header.Set(middleware.HeaderSignatureTimestamp, now)
+	header.Set(middleware.HeaderSignatureNonce, "nonce")
+	header.Set(middleware.HeaderSignature, middleware.HMACSignature(secret, now, "nonce", "arith.multiply", json.RawMessage(`{ "a": 1, "b": 2 }`)))
+
+	for i, expected := range []string{ok, invalid} {
+		if out := do(http.DefaultClient, header); out != expected {
+			t.Errorf("replay %d: got %s expected %s", i, out, expected)
+		}
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header.Set(middleware.HeaderSignatureTimestamp, old)
+	header.Set(middleware.HeaderSignature, middleware.HMACSignature(secret, old, "nonce2", "arith.multiply", json.RawMessage(`{ "a": 1, "b": 2 }`)))
+	header.Set(middleware.HeaderSignatureNonce, "nonce2")
+	if out := do(http.DefaultClient, header); out != invalid {
+		t.Errorf("expired: got %s expected %s", out, invalid)
+	}
+}
+
+func TestMiddlewareHMACAuthNonceStoreError(t *testing.T) {
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(middleware.WithHMACAuth(middleware.HMACOptions{Keys: map[string][]byte{"billing": []byte("secret")}, Nonces: errNonceStore{}}))
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	in := `{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 2 }, "id": 1 }`
+	client := &http.Client{Transport: middleware.NewHMACRoundTripper("billing", []byte("secret"), nil)}
+	res, err := client.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := string(resp); out != `{"jsonrpc":"2.0","id":1,"error":{"code":500,"message":"Internal error"}}` {
+		t.Errorf("got %s, expected internal error without details", out)
+	}
+}
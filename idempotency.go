@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+const (
+	// CodeIdempotencyConflict is a JSON-RPC error code for duplicate requests which are still in progress.
+	CodeIdempotencyConflict = http.StatusConflict
+
+	// CodeIdempotencyMismatch is a JSON-RPC error code for idempotency keys reused with other params.
+	CodeIdempotencyMismatch = http.StatusUnprocessableEntity
+)
+
+// HeaderIdempotencyKey is a header with idempotency key.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+var (
+	// ErrIdempotencyInProgress is returned by IdempotencyStore for keys which are still in progress.
+	ErrIdempotencyInProgress = errors.New("idempotency key is in progress")
+
+	// ErrIdempotencyMismatch is returned by IdempotencyStore for keys started with other params hash.
+	ErrIdempotencyMismatch = errors.New("idempotency key is used with other params")
+)
+
+// IdempotencyStore stores responses by idempotency keys. Implementation must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Start marks key with params hash as in progress for ttl. It returns stored response if key is completed,
+	// ErrIdempotencyMismatch if key was started with other hash or ErrIdempotencyInProgress if key is in progress.
+	// Nil response and error mean that key is started.
+	Start(ctx context.Context, key, hash string, ttl time.Duration) (*zenrpc.Response, error)
+
+	// Finish stores response for started key for ttl.
+	Finish(ctx context.Context, key string, r zenrpc.Response, ttl time.Duration) error
+
+	// Cancel removes started key, so request could be retried.
+	Cancel(ctx context.Context, key string) error
+}
+
+// IdempotencyRule configures idempotency for method.
+type IdempotencyRule struct {
+	// TTL is a time to keep response.
+	TTL time.Duration
+
+	// Param is a name of params field with idempotency key. It is used if Idempotency-Key header is empty.
+	Param string
+}
+
+// WithIdempotency stores responses by client, method and idempotency key from Idempotency-Key header or params field
+// for methods by `namespace.method`, `namespace` or `*` keys. Repeated requests get stored response,
+// concurrent duplicates get CodeIdempotencyConflict error, requests with the same key and other params get
+// CodeIdempotencyMismatch error. Requests without key are processed as usual.
+// Client is Principal ID from context (see WithAPIKeyAuth) or IP, requests of unknown clients are processed as usual.
+// Internal errors (500 or < 0) and panics are not stored, so requests could be retried.
+func WithIdempotency(store IdempotencyStore, methods map[string]IdempotencyRule) zenrpc.MiddlewareFunc {
+	methods = lowerKeys(methods)
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			rule, ok := methodValue(methods, namespace, method)
+			if !ok || rule.TTL <= 0 {
+				return h(ctx, method, params)
+			}
+
+			key := idempotencyKey(ctx, rule.Param, params)
+			if key == "" {
+				return h(ctx, method, params)
+			}
+
+			client := appkit.IPFromContext(ctx)
+			if p := PrincipalFromContext(ctx); p != nil {
+				client = p.ID
+			}
+
+			// responses must not be shared between unknown clients
+			if client == "" {
+				return h(ctx, method, params)
+			}
+
+			key = client + "|" + namespace + "." + method + "|" + key
+			hash := sha256.Sum256(canonicalJSON(params))
+
+			stored, err := store.Start(ctx, key, hex.EncodeToString(hash[:]), rule.TTL)
+			switch {
+			case errors.Is(err, ErrIdempotencyInProgress):
+				return zenrpc.NewResponseError(nil, CodeIdempotencyConflict, "Request is in progress", nil)
+			case errors.Is(err, ErrIdempotencyMismatch):
+				return zenrpc.NewResponseError(nil, CodeIdempotencyMismatch, "Idempotency key is used with other params", nil)
+			case err != nil:
+				r := zenrpc.NewResponseError(nil, http.StatusInternalServerError, "Internal error", nil)
+				r.Error.Err = err
+				return r
+			case stored != nil:
+				stored.Version = zenrpc.Version
+				return *stored
+			}
+
+			// context could be canceled, but key must be finished or canceled (e.g. on panic)
+			finished := false
+			defer func() {
+				if !finished {
+					_ = store.Cancel(context.WithoutCancel(ctx), key)
+				}
+			}()
+
+			r := h(ctx, method, params)
+
+			ctx = context.WithoutCancel(ctx)
+			if r.Error != nil && (r.Error.Code == http.StatusInternalServerError || r.Error.Code < 0) {
+				return r
+			}
+
+			stored = &zenrpc.Response{Result: r.Result}
+			if r.Error != nil {
+				e := *r.Error
+				stored.Error = &e
+			}
+
+			finished = store.Finish(ctx, key, *stored, rule.TTL) == nil
+			return r
+		}
+	}
+}
+
+// idempotencyKey returns key from header or params field.
+func idempotencyKey(ctx context.Context, param string, params json.RawMessage) string {
+	if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+		if key := req.Header.Get(HeaderIdempotencyKey); key != "" {
+			return key
+		}
+	}
+
+	if param == "" || len(params) == 0 {
+		return ""
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return ""
+	}
+
+	var key string
+	if err := json.Unmarshal(fields[param], &key); err != nil {
+		return ""
+	}
+
+	return key
+}
+
+// memoryIdempotencyStore is an in-memory IdempotencyStore.
+type memoryIdempotencyStore struct {
+	entries     map[string]idempotencyEntry
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+type idempotencyEntry struct {
+	hash     string
+	response *zenrpc.Response
+	expires  time.Time
+}
+
+// NewMemoryIdempotencyStore returns in-memory IdempotencyStore. Expired keys are removed periodically.
+func NewMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{
+		entries:     make(map[string]idempotencyEntry),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *memoryIdempotencyStore) Start(_ context.Context, key, hash string, ttl time.Duration) (*zenrpc.Response, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// remove expired keys once a minute
+	if now.Sub(s.lastCleanup) >= time.Minute {
+		s.lastCleanup = now
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		switch {
+		case e.hash != hash:
+			return nil, ErrIdempotencyMismatch
+		case e.response == nil:
+			return nil, ErrIdempotencyInProgress
+		}
+
+		r := *e.response
+		return &r, nil
+	}
+
+	s.entries[key] = idempotencyEntry{hash: hash, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Finish(_ context.Context, key string, r zenrpc.Response, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+
+	e.response, e.expires = &r, time.Now().Add(ttl)
+	s.entries[key] = e
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Cancel(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// pgIdempotencyStore is a Postgres IdempotencyStore.
+type pgIdempotencyStore struct {
+	db    *pg.DB
+	table pg.Ident
+}
+
+// storedResponse is a stored part of zenrpc.Response.
+type storedResponse struct {
+	Result *json.RawMessage `json:"result,omitempty"`
+	Error  *zenrpc.Error    `json:"error,omitempty"`
+}
+
+// NewPGIdempotencyStore returns IdempotencyStore with responses in Postgres table:
+//
+//	CREATE TABLE "idempotencyKeys" (
+//	  "key"       text PRIMARY KEY,
+//	  "hash"      text NOT NULL,
+//	  "response"  jsonb,
+//	  "expiresAt" timestamptz NOT NULL
+//	);
+//
+// Expired keys are replaced by new requests, but they should be removed periodically, e.g. by cron.
+func NewPGIdempotencyStore(db *pg.DB, table string) *pgIdempotencyStore {
+	return &pgIdempotencyStore{db: db, table: pg.Ident(table)}
+}
+
+func (s *pgIdempotencyStore) Start(ctx context.Context, key, hash string, ttl time.Duration) (*zenrpc.Response, error) {
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO ? ("key", "hash", "expiresAt") VALUES (?, ?, ?)
+		ON CONFLICT ("key") DO UPDATE SET "hash" = EXCLUDED."hash", "response" = NULL, "expiresAt" = EXCLUDED."expiresAt"
+		WHERE ?."expiresAt" < ?`,
+		s.table, key, hash, now.Add(ttl), s.table, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.RowsAffected() > 0 {
+		return nil, nil
+	}
+
+	// jsonb is scanned as text, NULL response is scanned as empty string
+	var storedHash, data string
+	if _, err = s.db.QueryOneContext(ctx, pg.Scan(&storedHash, &data), `SELECT "hash", "response" FROM ? WHERE "key" = ?`, s.table, key); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			// key was removed concurrently
+			return nil, ErrIdempotencyInProgress
+		}
+
+		return nil, err
+	}
+
+	switch {
+	case storedHash != hash:
+		return nil, ErrIdempotencyMismatch
+	case data == "":
+		return nil, ErrIdempotencyInProgress
+	}
+
+	var sr storedResponse
+	if err = json.Unmarshal([]byte(data), &sr); err != nil {
+		return nil, err
+	}
+
+	return &zenrpc.Response{Result: sr.Result, Error: sr.Error}, nil
+}
+
+func (s *pgIdempotencyStore) Finish(ctx context.Context, key string, r zenrpc.Response, ttl time.Duration) error {
+	data, err := json.Marshal(storedResponse{Result: r.Result, Error: r.Error})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE ? SET "response" = ?, "expiresAt" = ? WHERE "key" = ?`,
+		s.table, string(data), time.Now().Add(ttl), key)
+	return err
+}
+
+func (s *pgIdempotencyStore) Cancel(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM ? WHERE "key" = ?`, s.table, key)
+	return err
+}
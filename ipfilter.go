@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// IPFilterRule is a list of allowed and denied IPs or CIDR ranges. Deny has priority over Allow.
+// Empty Allow means all IPs are allowed.
+type IPFilterRule struct {
+	Allow []string
+	Deny  []string
+}
+
+// ipFilterRule is a parsed IPFilterRule.
+type ipFilterRule struct {
+	allow, deny []netip.Prefix
+}
+
+// IPFilter holds IP filter rules. Rules could be reloaded at runtime via Update.
+type IPFilter struct {
+	mu    sync.RWMutex
+	rules map[string]ipFilterRule
+}
+
+// NewIPFilter returns IPFilter with rules by `namespace.method`, `namespace` or `*` keys. The most specific rule is used.
+func NewIPFilter(rules map[string]IPFilterRule) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Update(rules); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Update replaces rules. Rules are not changed if any of them is invalid.
+func (f *IPFilter) Update(rules map[string]IPFilterRule) error {
+	parsed := make(map[string]ipFilterRule, len(rules))
+	for key, rule := range rules {
+		allow, err := parsePrefixes(rule.Allow)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", key, err)
+		}
+
+		deny, err := parsePrefixes(rule.Deny)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", key, err)
+		}
+
+		parsed[strings.ToLower(key)] = ipFilterRule{allow: allow, deny: deny}
+	}
+
+	f.mu.Lock()
+	f.rules = parsed
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Allowed checks IP for method. It returns false and rule key if IP is rejected.
+func (f *IPFilter) Allowed(namespace, method, ip string) (bool, string) {
+	f.mu.RLock()
+	key, ok := methodKey(f.rules, namespace, method)
+	rule := f.rules[key]
+	f.mu.RUnlock()
+
+	if !ok {
+		return true, ""
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(rule.allow) == 0 && len(rule.deny) == 0, key
+	}
+
+	addr = addr.Unmap()
+	if containsAddr(rule.deny, addr) {
+		return false, key
+	}
+
+	return len(rule.allow) == 0 || containsAddr(rule.allow, addr), key
+}
+
+// IPFilterOptions configures WithIPFilter.
+type IPFilterOptions struct {
+	// TrustedProxies are CIDR ranges of proxies. If request is from trusted proxy, client IP is taken
+	// from X-Forwarded-For or X-Real-IP headers and is set to context via appkit.NewIPContext.
+	TrustedProxies []netip.Prefix
+
+	// Print logs rejected requests, e.g. slog.WarnContext.
+	Print Print
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// WithIPFilter allows or denies methods by client IP via IPFilter rules. Client IP is taken from appkit.IPFromContext
+// or from http request with trusted proxies handling. Rejected requests get CodeForbidden error.
+// It exposes metric `app_rpc_ip_rejected_total` with labels: rule, server.
+func WithIPFilter(serverName string, filter *IPFilter, opts IPFilterOptions) zenrpc.MiddlewareFunc {
+	if serverName == "" {
+		serverName = "rpc"
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	rejected := registerCollector(opts.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "ip_rejected_total",
+		Help:      "Requests rejected by IP filter count by rule.",
+	}, []string{"rule", "server"}))
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			ip := appkit.IPFromContext(ctx)
+			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil && len(opts.TrustedProxies) > 0 {
+				if clientIP := realIP(req, opts.TrustedProxies); clientIP != "" {
+					ip = clientIP
+					ctx = appkit.NewIPContext(ctx, ip)
+				}
+			}
+
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			if allowed, rule := filter.Allowed(namespace, method, ip); !allowed {
+				rejected.WithLabelValues(rule, serverName).Inc()
+				if opts.Print != nil {
+					opts.Print(ctx, "rpc ip rejected", append(additionalArgs(ctx),
+						"method", fullMethodName(serverName, namespace, method),
+						"rule", rule,
+						"xRequestId", appkit.XRequestIDFromContext(ctx),
+					)...)
+				}
+
+				return zenrpc.NewResponseError(nil, CodeForbidden, "Forbidden", nil)
+			}
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// realIP returns client IP from X-Forwarded-For or X-Real-IP headers if request is from trusted proxy.
+// X-Forwarded-For is read from right to left, the first untrusted IP is a client IP.
+func realIP(req *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !containsAddr(proxies, remote.Unmap()) {
+		return host
+	}
+
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(ips[i]))
+			if err != nil {
+				break
+			}
+
+			if i == 0 || !containsAddr(proxies, addr.Unmap()) {
+				return addr.Unmap().String()
+			}
+		}
+	}
+
+	if xri, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return xri.Unmap().String()
+	}
+
+	return host
+}
+
+// parsePrefixes parses IPs and CIDR ranges.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package middleware_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareIPFilter(t *testing.T) {
+	filter, err := middleware.NewIPFilter(map[string]middleware.IPFilterRule{
+		"arith":          {Allow: []string{"10.0.0.0/8"}},
+		"arith.multiply": {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(middleware.WithIPFilter("test", filter, middleware.IPFilterOptions{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Registerer:     reg,
+	}))
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	forbidden := `{"jsonrpc":"2.0","id":1,"error":{"code":403,"message":"Forbidden"}}`
+	tc := []struct {
+		xff, method, out string
+	}{
+		{"", "divide", forbidden},
+		{"10.1.2.3, 127.0.0.2", "divide", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"10.1.2.3", "multiply", forbidden},
+		{"10.2.2.3", "multiply", `{"jsonrpc":"2.0","id":1,"result":2}`},
+		{"10.1.2.3, 192.168.1.1", "divide", forbidden},
+	}
+
+	do := func(xff, method string) string {
+		in := `{"jsonrpc": "2.0", "method": "arith.` + method + `", "params": { "a": 1, "b": 2 }, "id": 1 }`
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(in))
+		req.Header.Set("Content-Type", "application/json")
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(resp)
+	}
+
+	for i, c := range tc {
+		if out := do(c.xff, c.method); out != c.out {
+			t.Errorf("%d: got %s expected %s", i, out, c.out)
+		}
+	}
+
+	expected := `
+# HELP app_rpc_ip_rejected_total Requests rejected by IP filter count by rule.
+# TYPE app_rpc_ip_rejected_total counter
+app_rpc_ip_rejected_total{rule="arith",server="test"} 2
+app_rpc_ip_rejected_total{rule="arith.multiply",server="test"} 1
+`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_ip_rejected_total"); err != nil {
+		t.Error(err)
+	}
+
+	// reload rules
+	if err = filter.Update(map[string]middleware.IPFilterRule{"*": {Deny: []string{"bad"}}}); err == nil {
+		t.Error("expected error for invalid rule")
+	}
+
+	if err = filter.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := do("", "divide"); out != tc[1].out {
+		t.Errorf("got %s after reload", out)
+	}
+}
@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+const (
+	// CodeTokenMissing is a JSON-RPC error code for requests without bearer token.
+	CodeTokenMissing = CodeUnauthorized
+
+	// CodeTokenInvalid is a JSON-RPC error code for tokens with invalid signature, issuer, audience, etc.
+	CodeTokenInvalid = 4011
+
+	// CodeTokenExpired is a JSON-RPC error code for expired tokens.
+	CodeTokenExpired = 4012
+
+	// CodeClaimsRequired is a JSON-RPC error code for tokens without claims required by method.
+	CodeClaimsRequired = CodeForbidden
+)
+
+type jwtClaimsKey struct{}
+
+// JWTKeyProvider returns keys for token signature verification. Implementation must be safe for concurrent use.
+type JWTKeyProvider interface {
+	// Key returns verification key by key id (`kid` header) and algorithm: []byte for HS256,
+	// *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+	Key(ctx context.Context, kid, alg string) (any, error)
+}
+
+// JWTKeyFunc is an in-process JWTKeyProvider.
+type JWTKeyFunc func(ctx context.Context, kid, alg string) (any, error)
+
+// Key implements JWTKeyProvider.
+func (f JWTKeyFunc) Key(ctx context.Context, kid, alg string) (any, error) {
+	return f(ctx, kid, alg)
+}
+
+// JWTClaimsRule checks token claims.
+type JWTClaimsRule func(claims jwt.MapClaims) bool
+
+// RequireClaim checks that claim equals value or contains value if claim is an array.
+func RequireClaim(name, value string) JWTClaimsRule {
+	return func(claims jwt.MapClaims) bool {
+		switch v := claims[name].(type) {
+		case string:
+			return v == value
+		case []any:
+			for _, item := range v {
+				if s, ok := item.(string); ok && s == value {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// JWTOptions configures WithJWT.
+type JWTOptions struct {
+	// Keys returns verification keys, e.g. JWKS from LoadJWKS or JWTKeyFunc.
+	Keys JWTKeyProvider
+
+	// Algorithms are allowed signing algorithms. Default is HS256, RS256 and ES256.
+	Algorithms []string
+
+	// Issuer and Audience are checked if set.
+	Issuer   string
+	Audience string
+
+	// Leeway is an allowed clock skew for exp, nbf and iat claims.
+	Leeway time.Duration
+
+	// AllowNoExpiration accepts tokens without exp claim. By default, exp claim is required.
+	AllowNoExpiration bool
+
+	// Require are claims rules by `namespace.method`, `namespace` or `*` keys. The most specific rules are used.
+	Require map[string][]JWTClaimsRule
+
+	// Public are methods allowed without valid token in the same format as Principal.Scopes.
+	Public []string
+
+	// PrincipalClaim is a claim used as Principal ID. Default is `sub`. Use bounded claim like `azp` or `client_id`
+	// (client application) with MetricsOptions.PrincipalLabel, but note that Principal ID also scopes idempotency keys.
+	PrincipalClaim string
+}
+
+// WithJWT validates `Authorization: Bearer` tokens and sets claims to context (see JWTClaimsFromContext).
+// Principal with `sub` claim (see PrincipalClaim) as ID and `scope` claim as scopes is also set to context for logs
+// and metrics.
+// Failures are returned as CodeTokenMissing, CodeTokenInvalid, CodeTokenExpired and CodeClaimsRequired errors,
+// so they are counted by WithMetrics and are not logged by WithErrorLogger. Public methods are called without claims
+// if token is missing or invalid. It panics if Keys is nil.
+func WithJWT(opts JWTOptions) zenrpc.MiddlewareFunc {
+	if opts.Keys == nil {
+		panic("middleware: JWTOptions.Keys is required")
+	}
+
+	if opts.PrincipalClaim == "" {
+		opts.PrincipalClaim = "sub"
+	}
+
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(opts.Algorithms), jwt.WithLeeway(opts.Leeway)}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	if !opts.AllowNoExpiration {
+		parserOpts = append(parserOpts, jwt.WithExpirationRequired())
+	}
+
+	opts.Require = lowerKeys(opts.Require)
+	parser, public := jwt.NewParser(parserOpts...), Principal{Scopes: opts.Public}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+
+			token := ""
+			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+				if auth := req.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+					token = strings.TrimSpace(auth[7:])
+				}
+			}
+
+			if token == "" {
+				if public.Allowed(namespace, method) {
+					return h(ctx, method, params)
+				}
+
+				return zenrpc.NewResponseError(nil, CodeTokenMissing, "Unauthorized", nil)
+			}
+
+			claims := jwt.MapClaims{}
+			_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+				kid, _ := t.Header["kid"].(string)
+				return opts.Keys.Key(ctx, kid, t.Method.Alg())
+			})
+
+			switch {
+			case err != nil && public.Allowed(namespace, method):
+				// invalid token is the same as missing token
+				return h(ctx, method, params)
+			case errors.Is(err, jwt.ErrTokenExpired):
+				return zenrpc.NewResponseError(nil, CodeTokenExpired, "Token expired", nil)
+			case err != nil:
+				return zenrpc.NewResponseError(nil, CodeTokenInvalid, "Invalid token", nil)
+			}
+
+			rules, _ := methodValue(opts.Require, namespace, method)
+			for _, rule := range rules {
+				if !rule(claims) {
+					return zenrpc.NewResponseError(nil, CodeClaimsRequired, "Forbidden", nil)
+				}
+			}
+
+			id, _ := claims[opts.PrincipalClaim].(string)
+			scope, _ := claims["scope"].(string)
+
+			ctx = NewJWTClaimsContext(ctx, claims)
+			ctx = NewPrincipalContext(ctx, &Principal{ID: id, Scopes: strings.Fields(scope)})
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// NewJWTClaimsContext creates new context with JWT claims.
+func NewJWTClaimsContext(ctx context.Context, claims jwt.MapClaims) context.Context {
+	return context.WithValue(ctx, jwtClaimsKey{}, claims)
+}
+
+// JWTClaimsFromContext returns JWT claims from context.
+func JWTClaimsFromContext(ctx context.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+// JWKS is a JWTKeyProvider with keys from JSON Web Key Set. Supported key types: RSA, EC (P-256, P-384, P-521) and oct.
+type JWKS struct {
+	keys map[string]any
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS loads JSON Web Key Set from file.
+func LoadJWKS(path string) (*JWKS, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(b)
+}
+
+// ParseJWKS parses JSON Web Key Set.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	ks := &JWKS{keys: make(map[string]any, len(set.Keys))}
+	for _, k := range set.Keys {
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.Kid, err)
+		}
+
+		ks.keys[k.Kid] = key
+	}
+
+	return ks, nil
+}
+
+// Key implements JWTKeyProvider. Empty kid is allowed for set with one key.
+func (ks *JWKS) Key(_ context.Context, kid, _ string) (any, error) {
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// key returns public or symmetric key.
+func (k jwk) key() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
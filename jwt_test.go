@@ -0,0 +1,140 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareJWT(t *testing.T) {
+	secret := []byte("secret")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := middleware.ParseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"ec","crv":"P-256","x":"` +
+		base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()) + `","y":"` +
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := middleware.JWTKeyFunc(func(ctx context.Context, kid, alg string) (any, error) {
+		if alg == jwt.SigningMethodHS256.Alg() {
+			return secret, nil
+		}
+
+		return jwks.Key(ctx, kid, alg)
+	})
+
+	var sub, principal string
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithJWT(middleware.JWTOptions{
+			Keys:           keys,
+			Issuer:         "test",
+			Audience:       "api",
+			Require:        map[string][]middleware.JWTClaimsRule{"arith.multiply": {middleware.RequireClaim("roles", "admin")}},
+			Public:         []string{"arith.multiply"},
+			PrincipalClaim: "azp",
+		}),
+		func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+			return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+				sub, _ = middleware.JWTClaimsFromContext(ctx).GetSubject()
+				if p := middleware.PrincipalFromContext(ctx); p != nil {
+					principal = p.ID
+				}
+				return h(ctx, method, params)
+			}
+		},
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	sign := func(method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := jwt.MapClaims{"sub": "user", "iss": "test", "aud": "api", "exp": exp}
+	admin := jwt.MapClaims{"sub": "admin", "azp": "backoffice", "iss": "test", "aud": "api", "exp": exp, "roles": []string{"admin"}}
+
+	tc := []struct {
+		token, method, out string
+	}{
+		{"", "divide", `{"jsonrpc":"2.0","id":1,"error":{"code":401,"message":"Unauthorized"}}`},
+		{sign(jwt.SigningMethodHS256, []byte("wrong"), "", claims), "divide", `{"jsonrpc":"2.0","id":1,"error":{"code":4011,"message":"Invalid token"}}`},
+		{sign(jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "other", "aud": "api"}), "divide", `{"jsonrpc":"2.0","id":1,"error":{"code":4011,"message":"Invalid token"}}`},
+		{sign(jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "test", "aud": "api", "exp": 1}), "divide", `{"jsonrpc":"2.0","id":1,"error":{"code":4012,"message":"Token expired"}}`},
+		{sign(jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "user", "iss": "test", "aud": "api"}), "divide", `{"jsonrpc":"2.0","id":1,"error":{"code":4011,"message":"Invalid token"}}`},
+		{"", "multiply", `{"jsonrpc":"2.0","id":1,"result":2}`},
+		{sign(jwt.SigningMethodHS256, []byte("wrong"), "", claims), "multiply", `{"jsonrpc":"2.0","id":1,"result":2}`},
+		{sign(jwt.SigningMethodES256, ecKey, "ec", claims), "divide", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{sign(jwt.SigningMethodES256, ecKey, "ec", claims), "multiply", `{"jsonrpc":"2.0","id":1,"error":{"code":403,"message":"Forbidden"}}`},
+		{sign(jwt.SigningMethodHS256, secret, "", admin), "multiply", `{"jsonrpc":"2.0","id":1,"result":2}`},
+	}
+
+	for i, c := range tc {
+		in := `{"jsonrpc": "2.0", "method": "arith.` + c.method + `", "params": { "a": 1, "b": 2 }, "id": 1 }`
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(in))
+		req.Header.Set("Content-Type", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(resp) != c.out {
+			t.Errorf("%d: got %s expected %s", i, resp, c.out)
+		}
+	}
+
+	// principal is a client, not a user
+	if sub != "admin" || principal != "backoffice" {
+		t.Errorf("got sub %q, principal %q from context", sub, principal)
+	}
+}
+
+func TestMiddlewareJWTWithoutKeys(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("WithJWT must panic without keys")
+		}
+	}()
+
+	middleware.WithJWT(middleware.JWTOptions{})
+}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vmkteam/appkit"
@@ -13,6 +18,8 @@ import (
 // ErrSkipLog is a special error for LogAttrs func. Log lines can be skipped.
 var ErrSkipLog = errors.New("skip log")
 
+const defaultLogResultSize = 1024
+
 // WithAPILogger logs via Printf function (e.g. log.Printf) all requests.
 func WithAPILogger(pf Printf, serverName string) zenrpc.MiddlewareFunc {
 	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
@@ -27,7 +34,7 @@ func WithAPILogger(pf Printf, serverName string) zenrpc.MiddlewareFunc {
 				appkit.VersionFromContext(ctx),
 				methodName,
 				time.Since(start),
-				params,
+				redactParams(ctx, method, params),
 				r.Error,
 				appkit.UserAgentFromContext(ctx),
 				appkit.XRequestIDFromContext(ctx),
@@ -46,33 +53,201 @@ func WithSLog(pf Print, serverName string, fn LogAttrs) zenrpc.MiddlewareFunc {
 			r := h(ctx, method, params)
 
 			// get additional args, check for ErrSkipLog
-			var args []any
-			if fn != nil {
-				args = fn(ctx, r)
-				if len(args) == 1 {
-					if e, ok := args[0].(error); ok && errors.Is(e, ErrSkipLog) {
-						return r
-					}
-				}
+			args, ok := logAttrs(ctx, r, fn)
+			if !ok {
+				return r
 			}
 
+			logArgs := rpcLogArgs(ctx, serverName, method, params, r, time.Since(start))
+			pf(ctx, "rpc", append(logArgs, args...)...)
+			return r
+		}
+	}
+}
+
+// SLogOptions configures WithSLogOpts.
+type SLogOptions struct {
+	// Logger is used for logging. Default is slog.Default().
+	Logger *slog.Logger
+
+	// LogAttrs returns additional args or ErrSkipLog.
+	LogAttrs LogAttrs
+
+	// Level returns log level for response. Default is DefaultLogLevel.
+	Level func(ctx context.Context, r zenrpc.Response) slog.Level
+
+	// Sampling are sampling policies by `namespace.method`, `namespace` or `*` (for all methods) keys.
+	// The most specific policy is used. Requests without policy are always logged.
+	Sampling map[string]LogSampling
+
+	// Result enables logging of marshalled response result in `result` arg. It is disabled if nil.
+	Result *LogResultOptions
+}
+
+// LogResultOptions configures result logging. Result is redacted with Redactor from context.
+type LogResultOptions struct {
+	// MaxSize is a max size of logged result in bytes, longer results are truncated. Default is 1024.
+	MaxSize int
+
+	// Methods are `namespace.method` or `namespace` for result logging. Empty value means all methods.
+	Methods []string
+
+	// AllowDebugFunc enables result logging only if it returns true for http request.
+	AllowDebugFunc AllowDebugFunc
+}
+
+// LogSampling is a sampling policy for successful requests. Errors are always logged.
+type LogSampling struct {
+	// Rate is a fraction of logged successful requests: 0 – none, 0.1 – 10%, 1 – all.
+	Rate float64
+
+	// SlowThreshold is a duration, requests slower than it are always logged. Zero value disables it.
+	SlowThreshold time.Duration
+}
+
+// DefaultLogLevel returns slog.LevelInfo for successful responses, slog.LevelError for internal errors
+// (ErrorCode==500, -32603 or server errors from -32099 to -32000) and slog.LevelWarn for other errors,
+// e.g. invalid request, params or unknown method.
+func DefaultLogLevel(_ context.Context, r zenrpc.Response) slog.Level {
+	switch {
+	case r.Error == nil:
+		return slog.LevelInfo
+	case r.Error.Code == http.StatusInternalServerError || r.Error.Code == zenrpc.InternalError,
+		r.Error.Code <= zenrpc.ServerError && r.Error.Code >= zenrpc.ServerError-99:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// WithSLogOpts logs requests via slog.Logger with log level by response and sampling policies by methods.
+// Log args are the same as in WithSLog.
+func WithSLogOpts(serverName string, opts SLogOptions) zenrpc.MiddlewareFunc {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	if opts.Level == nil {
+		opts.Level = DefaultLogLevel
+	}
+
+	opts.Sampling = lowerKeys(opts.Sampling)
+
+	var resultMethods map[string]bool
+	if opts.Result != nil {
+		if opts.Result.MaxSize <= 0 {
+			opts.Result.MaxSize = defaultLogResultSize
+		}
+
+		resultMethods = make(map[string]bool, len(opts.Result.Methods))
+		for _, m := range opts.Result.Methods {
+			resultMethods[strings.ToLower(m)] = true
+		}
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			start := time.Now()
+			r := h(ctx, method, params)
 			t := time.Since(start)
-			logArgs := append(additionalArgs(ctx), []any{
-				"method", fullMethodName(serverName, zenrpc.NamespaceFromContext(ctx), method),
-				"duration", t.String(),
-				"durationMS", t.Milliseconds(),
-				"params", params,
-				"err", r.Error,
-				"userAgent", appkit.UserAgentFromContext(ctx),
-				"xRequestId", appkit.XRequestIDFromContext(ctx),
-			}...)
 
-			pf(ctx, "rpc", append(logArgs, args...)...)
+			if r.Error == nil && !opts.sampled(zenrpc.NamespaceFromContext(ctx), method, t) {
+				return r
+			}
+
+			// get additional args, check for ErrSkipLog
+			args, ok := logAttrs(ctx, r, opts.LogAttrs)
+			if !ok {
+				return r
+			}
+
+			logArgs := rpcLogArgs(ctx, serverName, method, params, r, t)
+			if opts.Result != nil && r.Result != nil && opts.Result.allowed(ctx, resultMethods, method) {
+				logArgs = append(logArgs, "result", truncateResult(redactResult(ctx, method, *r.Result), opts.Result.MaxSize))
+			}
+
+			opts.Logger.Log(ctx, opts.Level(ctx, r), "rpc", append(logArgs, args...)...)
 			return r
 		}
 	}
 }
 
+// sampled checks sampling policy for successful request.
+func (o SLogOptions) sampled(namespace, method string, t time.Duration) bool {
+	if len(o.Sampling) == 0 {
+		return true
+	}
+
+	s, ok := methodValue(o.Sampling, namespace, method)
+	switch {
+	case !ok:
+		return true
+	case s.SlowThreshold > 0 && t >= s.SlowThreshold:
+		return true
+	default:
+		return s.Rate > 0 && (s.Rate >= 1 || rand.Float64() < s.Rate) //nolint:gosec // sampling
+	}
+}
+
+// allowed checks result logging for method and http request.
+func (o LogResultOptions) allowed(ctx context.Context, methods map[string]bool, method string) bool {
+	if len(methods) > 0 {
+		if _, ok := methodValue(methods, zenrpc.NamespaceFromContext(ctx), method); !ok {
+			return false
+		}
+	}
+
+	if o.AllowDebugFunc == nil {
+		return true
+	}
+
+	req, ok := zenrpc.RequestFromContext(ctx)
+	if !ok || req == nil {
+		return false
+	}
+
+	reqClone := req.Clone(ctx)
+	return reqClone != nil && o.AllowDebugFunc(reqClone)
+}
+
+// truncateResult returns result as string truncated to maxSize bytes.
+func truncateResult(result json.RawMessage, maxSize int) string {
+	if len(result) <= maxSize {
+		return string(result)
+	}
+
+	return strings.ToValidUTF8(string(result[:maxSize]), "") + "...(truncated " + strconv.Itoa(len(result)-maxSize) + " bytes)"
+}
+
+// logAttrs returns additional args from LogAttrs func. It returns false if log must be skipped.
+func logAttrs(ctx context.Context, r zenrpc.Response, fn LogAttrs) ([]any, bool) {
+	if fn == nil {
+		return nil, true
+	}
+
+	args := fn(ctx, r)
+	if len(args) == 1 {
+		if e, ok := args[0].(error); ok && errors.Is(e, ErrSkipLog) {
+			return nil, false
+		}
+	}
+
+	return args, true
+}
+
+// rpcLogArgs returns common log args for rpc request.
+func rpcLogArgs(ctx context.Context, serverName, method string, params json.RawMessage, r zenrpc.Response, t time.Duration) []any {
+	return append(additionalArgs(ctx), []any{
+		"method", fullMethodName(serverName, zenrpc.NamespaceFromContext(ctx), method),
+		"duration", t.String(),
+		"durationMS", t.Milliseconds(),
+		"params", redactParams(ctx, method, params),
+		"err", r.Error,
+		"userAgent", appkit.UserAgentFromContext(ctx),
+		"xRequestId", appkit.XRequestIDFromContext(ctx),
+	}...)
+}
+
 func additionalArgs(ctx context.Context) []any {
 	r := make([]any, 0, 4)
 	r = append(r, "ip", appkit.IPFromContext(ctx))
@@ -88,5 +263,9 @@ func additionalArgs(ctx context.Context) []any {
 		r = append(r, "version", v)
 	}
 
+	if p := PrincipalFromContext(ctx); p != nil {
+		r = append(r, "principal", p.ID)
+	}
+
 	return r
 }
@@ -3,6 +3,8 @@ package middleware
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -10,65 +12,340 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vmkteam/appkit"
 	"github.com/vmkteam/zenrpc/v2"
+	"go.opentelemetry.io/otel/metric"
 )
 
 const methodNotFound = "methodNotFound"
 
+const (
+	defaultMetricsNamespace = "app"
+	defaultMetricsSubsystem = "rpc"
+)
+
 //nolint:gochecknoglobals // need for once metrics registration
 var (
 	registerMetricsOnce sync.Once
 
 	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "app",
-		Subsystem: "rpc",
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
 		Name:      "error_requests_total",
 		Help:      "Error requests count by method and error code.",
 	}, []string{"method", "code", "platform", "version", "server"})
 	rpcDurations = prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace: "app",
-		Subsystem: "rpc",
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
 		Name:      "responses_duration_seconds",
 		Help:      "Response time by method and error code.",
 	}, []string{"method", "code", "platform", "version", "server"})
+	rpcInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "in_flight_requests",
+		Help:      "Current number of requests being served by method.",
+	}, []string{"method", "server"})
+	rpcRequestSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "request_size_bytes",
+		Help:      "Request params size by method and error code.",
+		Buckets:   sizeBuckets,
+	}, []string{"method", "code", "platform", "version", "server"})
+	rpcResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "response_size_bytes",
+		Help:      "Response result size by method and error code.",
+		Buckets:   sizeBuckets,
+	}, []string{"method", "code", "platform", "version", "server"})
+
+	// sizeBuckets are buckets from 64B to 4MB.
+	sizeBuckets = prometheus.ExponentialBuckets(64, 4, 9)
 )
 
+// MetricsOptions configures metrics for WithMetricsOpts.
+type MetricsOptions struct {
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+
+	// Namespace and Subsystem are metrics name prefixes. Defaults are "app" and "rpc".
+	Namespace string
+	Subsystem string
+
+	// Buckets are duration histogram buckets in seconds. Default is prometheus.DefBuckets.
+	// Buckets of already registered collector are used if histogram is registered again with other buckets.
+	Buckets []float64
+
+	// NativeHistogram enables Prometheus native (sparse) histogram for durations in addition to classic buckets.
+	NativeHistogram bool
+
+	// ConstLabels are added to every metric.
+	ConstLabels prometheus.Labels
+
+	// PlatformLabel and VersionLabel normalize client-controlled platform and version labels, e.g. SemverMinorLabel.
+	// Changed and dropped values are counted in `app_rpc_label_normalized_total` metric.
+	PlatformLabel LabelNormalizer
+	VersionLabel  LabelNormalizer
+
+	// PrincipalLabel adds principal label with Principal.ID from context (see WithAPIKeyAuth) to metrics with code label.
+	// WithHeaders must be used before metrics middleware, and auth middleware after it.
+	// Every principal is a separate series, so use it only with bounded set of principals, e.g. API keys.
+	// It is not safe with WithJWT by default, because `sub` claim is a user, see JWTOptions.PrincipalClaim.
+	PrincipalLabel bool
+
+	// MeterProvider enables OpenTelemetry metrics alongside Prometheus according to semantic conventions for RPC:
+	// `rpc.server.duration`, `rpc.server.request.size` and `rpc.server.response.size`.
+	MeterProvider metric.MeterProvider
+}
+
+// rpcMetrics holds collectors used by metrics middleware.
+type rpcMetrics struct {
+	errors       *prometheus.CounterVec
+	durations    prometheus.ObserverVec
+	inFlight     *prometheus.GaugeVec
+	requestSize  prometheus.ObserverVec
+	responseSize prometheus.ObserverVec
+
+	platformLabel  LabelNormalizer
+	versionLabel   LabelNormalizer
+	principalLabel bool
+	normalized     *prometheus.CounterVec
+
+	otel *otelRPCMetrics
+}
+
 // WithMetrics logs duration of RPC requests via Prometheus. Default serverName is rpc will be in server label.
-// It exposes two metrics: `app_rpc_error_requests_total` and `app_rpc_responses_duration_seconds`.
-// Labels: method, code, platform, version, server.
+// It exposes metrics: `app_rpc_error_requests_total`, `app_rpc_responses_duration_seconds`, `app_rpc_request_size_bytes`,
+// `app_rpc_response_size_bytes` with labels: method, code, platform, version, server.
+// And `app_rpc_in_flight_requests` with labels: method, server.
 func WithMetrics(serverName string) zenrpc.MiddlewareFunc {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(rpcErrors, rpcDurations, rpcInFlight, rpcRequestSize, rpcResponseSize)
+	})
+
+	m := rpcMetrics{
+		errors:       rpcErrors,
+		durations:    rpcDurations,
+		inFlight:     rpcInFlight,
+		requestSize:  rpcRequestSize,
+		responseSize: rpcResponseSize,
+	}
+	return m.middleware(serverName)
+}
+
+// WithMetricsOpts is the same as WithMetrics, but uses own collectors described by MetricsOptions.
+// Durations are exposed as `app_rpc_responses_duration_histogram_seconds` histogram instead of summary, so they could be
+// aggregated across instances. Middleware could be created many times with the same options: already registered
+// collectors are reused (including their buckets). It panics if collector with the same name, but other labels
+// is already registered, e.g. with other PrincipalLabel or ConstLabels.
+func WithMetricsOpts(serverName string, opts MetricsOptions) zenrpc.MiddlewareFunc {
+	m := newRPCMetrics(opts)
+	return m.middleware(serverName)
+}
+
+func newRPCMetrics(opts MetricsOptions) rpcMetrics {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	if opts.Namespace == "" {
+		opts.Namespace = defaultMetricsNamespace
+	}
+
+	if opts.Subsystem == "" {
+		opts.Subsystem = defaultMetricsSubsystem
+	}
+
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	labels := []string{"method", "code", "platform", "version", "server"}
+	if opts.PrincipalLabel {
+		labels = append(labels, "principal")
+	}
+
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "error_requests_total",
+		Help:        "Error requests count by method and error code.",
+		ConstLabels: opts.ConstLabels,
+	}, labels)
+
+	ho := prometheus.HistogramOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "responses_duration_histogram_seconds",
+		Help:        "Response time by method and error code.",
+		ConstLabels: opts.ConstLabels,
+		Buckets:     opts.Buckets,
+	}
+	if opts.NativeHistogram {
+		ho.NativeHistogramBucketFactor = 1.1
+		ho.NativeHistogramMaxBucketNumber = 100
+		ho.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	inFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "in_flight_requests",
+		Help:        "Current number of requests being served by method.",
+		ConstLabels: opts.ConstLabels,
+	}, []string{"method", "server"})
+
+	requestSize := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "request_size_bytes",
+		Help:        "Request params size by method and error code.",
+		ConstLabels: opts.ConstLabels,
+		Buckets:     sizeBuckets,
+	}, labels)
+
+	responseSize := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "response_size_bytes",
+		Help:        "Response result size by method and error code.",
+		ConstLabels: opts.ConstLabels,
+		Buckets:     sizeBuckets,
+	}, labels)
+
+	normalized := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opts.Namespace,
+		Subsystem:   opts.Subsystem,
+		Name:        "label_normalized_total",
+		Help:        "Normalized or dropped client label values count by label and action.",
+		ConstLabels: opts.ConstLabels,
+	}, []string{"label", "action", "server"})
+
+	var om *otelRPCMetrics
+	if opts.MeterProvider != nil {
+		om = newOTelRPCMetrics(opts.MeterProvider)
+	}
+
+	return rpcMetrics{
+		otel: om,
+
+		platformLabel:  opts.PlatformLabel,
+		versionLabel:   opts.VersionLabel,
+		principalLabel: opts.PrincipalLabel,
+		normalized:     registerCollector(opts.Registerer, normalized),
+
+		errors:       registerCollector(opts.Registerer, errs),
+		durations:    registerCollector(opts.Registerer, prometheus.NewHistogramVec(ho, labels)),
+		inFlight:     registerCollector(opts.Registerer, inFlight),
+		requestSize:  registerCollector(opts.Registerer, requestSize),
+		responseSize: registerCollector(opts.Registerer, responseSize),
+	}
+}
+
+// registerCollector registers collector or returns already registered one with the same description.
+// It panics if collector with the same name, but other type or labels is already registered.
+func registerCollector[T prometheus.Collector](r prometheus.Registerer, c T) T {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+
+		panic(fmt.Errorf("middleware: metrics registration conflict, use other Registerer, Namespace or Subsystem: %w", err))
+	}
+
+	return c
+}
+
+func (m rpcMetrics) middleware(serverName string) zenrpc.MiddlewareFunc {
 	if serverName == "" {
 		serverName = "rpc"
 	}
 
-	registerMetricsOnce.Do(func() {
-		prometheus.MustRegister(rpcErrors, rpcDurations)
-	})
-
 	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
 		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			name, namespace := method, zenrpc.NamespaceFromContext(ctx)
+			if namespace != "" {
+				name = namespace + "." + method
+			}
+
+			// gauge is decreased on panic too
+			inFlight := m.inFlight.WithLabelValues(name, serverName)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
 			start, code := time.Now(), ""
 			r := h(ctx, method, params)
 
-			// log metrics
-			if n := zenrpc.NamespaceFromContext(ctx); n != "" {
-				method = n + "." + method
-			}
-
 			// set platform & version
-			platform, version := appkit.PlatformFromContext(ctx), appkit.VersionFromContext(ctx)
+			platform := m.labelValue("platform", appkit.PlatformFromContext(ctx), m.platformLabel, serverName)
+			version := m.labelValue("version", appkit.VersionFromContext(ctx), m.versionLabel, serverName)
 
 			if r.Error != nil {
 				if r.Error.Code == zenrpc.MethodNotFound {
-					method = methodNotFound
+					// unknown methods are not stored for in-flight gauge
+					m.inFlight.DeleteLabelValues(name, serverName)
+					name = methodNotFound
 				}
 
 				code = strconv.Itoa(r.Error.Code)
-				rpcErrors.WithLabelValues(method, code, platform, version, serverName).Inc()
 			}
 
-			rpcDurations.WithLabelValues(method, code, platform, version, serverName).Observe(time.Since(start).Seconds())
+			lv := []string{name, code, platform, version, serverName}
+			if m.principalLabel {
+				principal := ""
+				if p := PrincipalFromContext(ctx); p != nil {
+					principal = p.ID
+				}
+				lv = append(lv, principal)
+			}
+
+			if r.Error != nil {
+				m.errors.WithLabelValues(lv...).Inc()
+			}
+
+			d := time.Since(start)
+			m.durations.WithLabelValues(lv...).Observe(d.Seconds())
+			m.requestSize.WithLabelValues(lv...).Observe(float64(len(params)))
+			m.responseSize.WithLabelValues(lv...).Observe(float64(resultSize(r)))
+
+			if m.otel != nil {
+				if name == methodNotFound {
+					namespace, method = "", methodNotFound
+				}
+				m.otel.record(ctx, namespace, method, r, d, len(params))
+			}
 
 			return r
 		}
 	}
 }
+
+// labelValue normalizes label value via fn and counts changed values.
+func (m rpcMetrics) labelValue(label, value string, fn LabelNormalizer, serverName string) string {
+	if fn == nil || value == "" {
+		return value
+	}
+
+	v := fn(value)
+	switch v {
+	case value:
+	case LabelOther:
+		m.normalized.WithLabelValues(label, "dropped", serverName).Inc()
+	default:
+		m.normalized.WithLabelValues(label, "normalized", serverName).Inc()
+	}
+
+	return v
+}
+
+// resultSize returns size of marshalled result.
+func resultSize(r zenrpc.Response) int {
+	if r.Result == nil {
+		return 0
+	}
+
+	return len(*r.Result)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// LabelOther is a label value for values dropped by LabelNormalizer.
+const LabelOther = "other"
+
+//nolint:gochecknoglobals // compiled once
+var semverMinorRe = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// LabelNormalizer rewrites client-controlled metric label value (e.g. platform or version) before it is used in metrics.
+// It should return LabelOther for values that must be dropped. Empty values are not passed to LabelNormalizer.
+type LabelNormalizer func(value string) string
+
+// SemverMinorLabel collapses semver-like versions to major.minor, e.g. "v1.2.3-beta" -> "1.2".
+// Non-semver values are replaced with LabelOther.
+func SemverMinorLabel() LabelNormalizer {
+	return func(value string) string {
+		m := semverMinorRe.FindStringSubmatch(value)
+		if m == nil {
+			return LabelOther
+		}
+
+		return m[1] + "." + m[2]
+	}
+}
+
+// AllowedValuesLabel keeps only given values, others are replaced with LabelOther.
+func AllowedValuesLabel(values ...string) LabelNormalizer {
+	return func(value string) string {
+		if slices.Contains(values, value) {
+			return value
+		}
+
+		return LabelOther
+	}
+}
+
+// RegexpLabel keeps only values matched by re, others are replaced with LabelOther.
+func RegexpLabel(re *regexp.Regexp) LabelNormalizer {
+	return func(value string) string {
+		if re.MatchString(value) {
+			return value
+		}
+
+		return LabelOther
+	}
+}
+
+// MaxDistinctLabel keeps first max distinct values, all next values are replaced with LabelOther.
+func MaxDistinctLabel(maxValues int) LabelNormalizer {
+	var mu sync.Mutex
+	seen := make(map[string]struct{}, maxValues)
+
+	return func(value string) string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if _, ok := seen[value]; ok {
+			return value
+		}
+
+		if len(seen) >= maxValues {
+			return LabelOther
+		}
+
+		seen[value] = struct{}{}
+		return value
+	}
+}
+
+// ChainLabel applies normalizers one by one. It stops on LabelOther value.
+func ChainLabel(normalizers ...LabelNormalizer) LabelNormalizer {
+	return func(value string) string {
+		for _, fn := range normalizers {
+			if value = fn(value); value == LabelOther {
+				break
+			}
+		}
+
+		return value
+	}
+}
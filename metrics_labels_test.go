@@ -0,0 +1,58 @@
+package middleware_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+)
+
+func TestLabelNormalizers(t *testing.T) {
+	tcs := []struct {
+		name string
+		fn   middleware.LabelNormalizer
+		in   []string
+		out  []string
+	}{
+		{
+			name: "semver",
+			fn:   middleware.SemverMinorLabel(),
+			in:   []string{"v1.0.0 alpha1", "2.15.3", "12.1", "latest"},
+			out:  []string{"1.0", "2.15", "12.1", middleware.LabelOther},
+		},
+		{
+			name: "allowed",
+			fn:   middleware.AllowedValuesLabel("ios", "android"),
+			in:   []string{"ios", "web", "android"},
+			out:  []string{"ios", middleware.LabelOther, "android"},
+		},
+		{
+			name: "regexp",
+			fn:   middleware.RegexpLabel(regexp.MustCompile(`^[a-z]{1,8}$`)),
+			in:   []string{"ios", "Ios", "verylongplatform"},
+			out:  []string{"ios", middleware.LabelOther, middleware.LabelOther},
+		},
+		{
+			name: "max distinct",
+			fn:   middleware.MaxDistinctLabel(2),
+			in:   []string{"a", "b", "c", "a", "b"},
+			out:  []string{"a", "b", middleware.LabelOther, "a", "b"},
+		},
+		{
+			name: "chain",
+			fn:   middleware.ChainLabel(middleware.SemverMinorLabel(), middleware.MaxDistinctLabel(1)),
+			in:   []string{"1.2.3", "1.2.4", "1.3.0", "dev"},
+			out:  []string{"1.2", "1.2", middleware.LabelOther, middleware.LabelOther},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, v := range tc.in {
+				if got := tc.fn(v); got != tc.out[i] {
+					t.Errorf("%q: got %q, expected %q", v, got, tc.out[i])
+				}
+			}
+		})
+	}
+}
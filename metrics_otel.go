@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// otelRPCMetrics holds OpenTelemetry instruments according to semantic conventions for RPC.
+type otelRPCMetrics struct {
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+func newOTelRPCMetrics(mp metric.MeterProvider) *otelRPCMetrics {
+	meter, m := mp.Meter(tracerName), &otelRPCMetrics{}
+
+	// on error instruments are no-op, so just report it
+	var err error
+	m.duration, err = meter.Float64Histogram("rpc.server.duration",
+		metric.WithDescription("Measures the duration of inbound RPC."),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	m.requestSize, err = meter.Int64Histogram("rpc.server.request.size",
+		metric.WithDescription("Measures the size of RPC request params."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	m.responseSize, err = meter.Int64Histogram("rpc.server.response.size",
+		metric.WithDescription("Measures the size of RPC response result."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	return m
+}
+
+// record records RPC call. Attributes: rpc.system, rpc.service, rpc.method, rpc.jsonrpc.error_code.
+func (m *otelRPCMetrics) record(ctx context.Context, namespace, method string, r zenrpc.Response, d time.Duration, requestSize int) {
+	attrs := make([]attribute.KeyValue, 0, 4)
+	attrs = append(attrs,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.service", namespace),
+		attribute.String("rpc.method", method),
+	)
+
+	if r.Error != nil {
+		attrs = append(attrs, attribute.Int("rpc.jsonrpc.error_code", r.Error.Code))
+	}
+
+	opt := metric.WithAttributeSet(attribute.NewSet(attrs...))
+	m.duration.Record(ctx, float64(d)/float64(time.Millisecond), opt)
+	m.requestSize.Record(ctx, int64(requestSize), opt)
+	m.responseSize.Record(ctx, int64(resultSize(r)), opt)
+}
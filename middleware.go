@@ -3,8 +3,8 @@ package middleware
 import (
 	"context"
 	"encoding/json"
+	"strings"
 
-	"github.com/labstack/echo/v4"
 	"github.com/vmkteam/appkit"
 	"github.com/vmkteam/zenrpc/v2"
 )
@@ -40,20 +40,9 @@ func WithNoCancelContext() zenrpc.MiddlewareFunc {
 }
 
 // WithHeaders sets User-Agent, Platform, Version, X-Country headers to context. User-Agent strips to 2048 chars, Platform and Version – to 64, X-Country - to 16.
+// Non-printable characters are removed. See WithHeadersOpts for configuration.
 func WithHeaders() zenrpc.MiddlewareFunc {
-	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
-		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
-			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
-				ctx = appkit.NewUserAgentContext(ctx, req.UserAgent())
-				ctx = appkit.NewPlatformContext(ctx, req.Header.Get("Platform"))
-				ctx = appkit.NewVersionContext(ctx, req.Header.Get("Version"))
-				ctx = appkit.NewXRequestIDContext(ctx, req.Header.Get(echo.HeaderXRequestID))
-				ctx = appkit.NewCountryContext(ctx, req.Header.Get("X-Country"))
-				ctx = appkit.NewMethodContext(ctx, method)
-			}
-			return h(ctx, method, params)
-		}
-	}
+	return WithHeadersOpts(HeadersOptions{})
 }
 
 // NewIPContext creates new context with IP.
@@ -152,6 +141,52 @@ func MethodFromContext(ctx context.Context) string {
 	return appkit.MethodFromContext(ctx)
 }
 
+// methodKey returns the most specific existing key from map: `namespace.method`, `namespace` or `*`.
+func methodKey[T any](m map[string]T, namespace, method string) (string, bool) {
+	for _, key := range []string{namespace + "." + method, namespace, "*"} {
+		if _, ok := m[key]; ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// lowerKeys returns copy of map with lower case keys, because zenrpc passes namespace and method in lower case.
+func lowerKeys[T any](m map[string]T) map[string]T {
+	if m == nil {
+		return nil
+	}
+
+	r := make(map[string]T, len(m))
+	for k, v := range m {
+		r[strings.ToLower(k)] = v
+	}
+
+	return r
+}
+
+// methodValue returns value from map by the most specific key: `namespace.method`, `namespace` or `*`.
+func methodValue[T any](m map[string]T, namespace, method string) (T, bool) {
+	key, ok := methodKey(m, namespace, method)
+	return m[key], ok
+}
+
+// debugAllowed checks that debug info could be added to response: isDevel is set or allowDebugFunc returns true for http request.
+func debugAllowed(ctx context.Context, isDevel bool, allowDebugFunc AllowDebugFunc) bool {
+	if isDevel {
+		return true
+	}
+
+	req, ok := zenrpc.RequestFromContext(ctx)
+	if !ok || req == nil || allowDebugFunc == nil {
+		return false
+	}
+
+	reqClone := req.Clone(ctx)
+	return reqClone != nil && allowDebugFunc(reqClone)
+}
+
 // fullMethodName returns namespace.method or serverName.namespace.method.
 func fullMethodName(serverName, namespace, method string) string {
 	name := namespace + "." + method
@@ -4,22 +4,31 @@ package middleware_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vmkteam/zenrpc-middleware"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/vmkteam/appkit"
 	"github.com/vmkteam/zenrpc/v2"
 	"github.com/vmkteam/zenrpc/v2/testdata"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 func newArithServer(isDevel bool, dbc *pg.DB, appName string) *zenrpc.Server {
@@ -177,3 +186,275 @@ func TestMiddlewareXRequestID(t *testing.T) {
 	bb, _ := httputil.DumpResponse(res, true)
 	log.Println(string(bb))
 }
+
+func TestMiddlewareMetricsOpts(t *testing.T) {
+	reg, mr := prometheus.NewRegistry(), sdkmetric.NewManualReader()
+	opts := middleware.MetricsOptions{
+		Registerer:    reg,
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(mr)),
+		Namespace:     "test",
+		Buckets:       []float64{0.1, 1},
+		ConstLabels:   prometheus.Labels{"app": "arith"},
+	}
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithMetricsOpts("metrics", opts),
+		middleware.WithMetricsOpts("metrics", opts), // registration must not panic
+	)
+
+	// summary of WithMetrics and histogram of WithMetricsOpts must not conflict in default registerer
+	middleware.WithMetrics("a")
+	middleware.WithMetricsOpts("b", middleware.MetricsOptions{})
+
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	in := `{"jsonrpc": "2.0", "method": "arith.divide", "params": { "a": 1, "b": 24 }, "id": 1 }`
+	res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]bool)
+	for _, mf := range mfs {
+		found[mf.GetName()] = true
+		switch mf.GetName() {
+		case "test_rpc_responses_duration_histogram_seconds":
+			h := mf.GetMetric()[0].GetHistogram()
+			if h.GetSampleCount() != 2 || len(h.GetBucket()) != 2 {
+				t.Errorf("got count=%d buckets=%d, expected count=2 buckets=2", h.GetSampleCount(), len(h.GetBucket()))
+			}
+		case "test_rpc_response_size_bytes":
+			if s := mf.GetMetric()[0].GetHistogram().GetSampleSum(); s != 2*float64(len(`{"Quo":0,"rem":1}`)) {
+				t.Errorf("got response size sum=%v", s)
+			}
+		case "test_rpc_in_flight_requests":
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v != 0 {
+				t.Errorf("got in flight=%v, expected 0", v)
+			}
+		}
+	}
+
+	for _, name := range []string{"test_rpc_responses_duration_histogram_seconds", "test_rpc_request_size_bytes", "test_rpc_response_size_bytes", "test_rpc_in_flight_requests"} {
+		if !found[name] {
+			t.Errorf("%s metric not found", name)
+		}
+	}
+
+	// check OpenTelemetry metrics
+	var rm metricdata.ResourceMetrics
+	if err = mr.Collect(t.Context(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name != "rpc.server.duration" {
+			continue
+		}
+
+		dp := m.Data.(metricdata.Histogram[float64]).DataPoints[0] //nolint:errcheck // test
+		if v, _ := dp.Attributes.Value("rpc.method"); dp.Count != 2 || v.AsString() != "divide" {
+			t.Errorf("got count=%d rpc.method=%q", dp.Count, v.AsString())
+		}
+		return
+	}
+
+	t.Error("rpc.server.duration metric not found")
+}
+
+func TestMiddlewareMetricsPanic(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := middleware.WithMetricsOpts("test", middleware.MetricsOptions{Registerer: reg})(func(context.Context, string, json.RawMessage) zenrpc.Response {
+		panic("boom")
+	})
+
+	func() {
+		defer func() { _ = recover() }()
+		h(t.Context(), "panic", nil)
+	}()
+
+	expected := `
+# HELP app_rpc_in_flight_requests Current number of requests being served by method.
+# TYPE app_rpc_in_flight_requests gauge
+app_rpc_in_flight_requests{method="panic",server="test"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_in_flight_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMiddlewareSLogOpts(t *testing.T) {
+	var buf bytes.Buffer
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithSLogOpts("slog", middleware.SLogOptions{
+			Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+			Sampling: map[string]middleware.LogSampling{
+				"arith.divide": {Rate: 0},
+				"arith":        {Rate: 1},
+			},
+		}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	for _, in := range []string{
+		`{"jsonrpc": "2.0", "method": "arith.divide", "params": { "a": 1, "b": 24 }, "id": 1 }`,
+		`{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 24 }, "id": 2 }`,
+		`{"jsonrpc": "2.0", "method": "arith.checkzenrpcerror", "params": [ true ], "id": 3 }`,
+	} {
+		res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	logs := buf.String()
+	if strings.Contains(logs, "method=slog.arith.divide") {
+		t.Error("divide must be skipped by sampling")
+	}
+
+	if !strings.Contains(logs, "level=INFO msg=rpc ip=\"\" method=slog.arith.multiply") {
+		t.Errorf("multiply must be logged with info level, got: %s", logs)
+	}
+
+	if !strings.Contains(logs, "level=ERROR msg=rpc ip=\"\" method=slog.arith.checkzenrpcerror") {
+		t.Errorf("checkzenrpcerror must be logged with error level, got: %s", logs)
+	}
+}
+
+func TestDefaultLogLevel(t *testing.T) {
+	for code, expected := range map[int]slog.Level{
+		0:                      slog.LevelInfo,
+		500:                    slog.LevelError,
+		zenrpc.InternalError:   slog.LevelError,
+		zenrpc.ServerError:     slog.LevelError,
+		-32099:                 slog.LevelError,
+		zenrpc.InvalidRequest:  slog.LevelWarn,
+		zenrpc.MethodNotFound:  slog.LevelWarn,
+		zenrpc.InvalidParams:   slog.LevelWarn,
+		zenrpc.ParseError:      slog.LevelWarn,
+		-32100:                 slog.LevelWarn,
+		middleware.CodeTimeout: slog.LevelWarn,
+		http.StatusNotFound:    slog.LevelWarn,
+	} {
+		var r zenrpc.Response
+		if code != 0 {
+			r = zenrpc.NewResponseError(nil, code, "", nil)
+		}
+
+		if got := middleware.DefaultLogLevel(t.Context(), r); got != expected {
+			t.Errorf("code %d: got %s, expected %s", code, got, expected)
+		}
+	}
+}
+
+func TestMiddlewareSlowLog(t *testing.T) {
+	var buf bytes.Buffer
+	sl := slog.New(slog.NewTextHandler(&buf, nil))
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithSlowLog(time.Hour, map[string]time.Duration{"arith.divide": time.Nanosecond}, sl.WarnContext, "slow", nil),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	for _, in := range []string{
+		`{"jsonrpc": "2.0", "method": "arith.divide", "params": { "a": 1, "b": 24 }, "id": 1 }`,
+		`{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 24 }, "id": 2 }`,
+	} {
+		res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	logs := buf.String()
+	if !strings.Contains(logs, `level=WARN msg="rpc slow" ip="" method=slow.arith.divide`) || !strings.Contains(logs, "threshold=1ns") {
+		t.Errorf("divide must be logged, got: %s", logs)
+	}
+
+	if strings.Contains(logs, "method=slow.arith.multiply") {
+		t.Errorf("multiply must not be logged, got: %s", logs)
+	}
+}
+
+func TestMiddlewareSlowLogSQL(t *testing.T) {
+	// query hooks are called without connection
+	db := pg.Connect(&pg.Options{Dialer: func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("no database")
+	}})
+	defer db.Close()
+
+	var buf bytes.Buffer
+	sl := slog.New(slog.NewTextHandler(&buf, nil))
+	h := middleware.WithSlowLog(time.Nanosecond, nil, sl.WarnContext, "slow", db)(func(ctx context.Context, _ string, _ json.RawMessage) zenrpc.Response {
+		_, _ = db.ExecContext(ctx, "SELECT ?", 42)
+		return zenrpc.Response{}
+	})
+
+	// queries out of request are not captured
+	_, _ = db.Exec("SELECT 1")
+	h(t.Context(), "report", nil)
+
+	logs := buf.String()
+	if !strings.Contains(logs, "SELECT 42") || strings.Contains(logs, "SELECT 1") {
+		t.Errorf("request SQL must be logged, got: %s", logs)
+	}
+}
+
+func TestMiddlewareSLogOptsResult(t *testing.T) {
+	var buf bytes.Buffer
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithRedactor(middleware.NewRedactor(middleware.RedactOptions{Rules: []string{"quo"}})),
+		middleware.WithSLogOpts("slog", middleware.SLogOptions{
+			Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+			Result: &middleware.LogResultOptions{MaxSize: 14, Methods: []string{"arith.divide"}},
+		}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	for _, in := range []string{
+		`{"jsonrpc": "2.0", "method": "arith.divide", "params": { "a": 1, "b": 24 }, "id": 1 }`,
+		`{"jsonrpc": "2.0", "method": "arith.multiply", "params": { "a": 1, "b": 24 }, "id": 2 }`,
+	} {
+		res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	logs := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(logs) != 2 {
+		t.Fatalf("got %d log lines, expected 2", len(logs))
+	}
+
+	if !strings.Contains(logs[0], `"result":"{\"Quo\":\"***\",\"...(truncated 7 bytes)"`) {
+		t.Errorf("divide result must be redacted and truncated, got: %s", logs[0])
+	}
+
+	if strings.Contains(logs[1], `"result"`) {
+		t.Errorf("multiply result must not be logged, got: %s", logs[1])
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// CodeRateLimited is a JSON-RPC error code for rate limited requests.
+const CodeRateLimited = http.StatusTooManyRequests
+
+// RateLimitKeyFunc returns client key for rate limiting. Empty key disables rate limiting for request.
+type RateLimitKeyFunc func(ctx context.Context) string
+
+// RateLimit is a token bucket limit: Rate tokens per second with Burst bucket size.
+type RateLimit struct {
+	// Rate is a number of tokens per second. Zero rate disables limit.
+	Rate float64
+
+	// Burst is a bucket size. Default is max(1, ceil(Rate)).
+	Burst int
+}
+
+// RateLimitStore stores token buckets. Implementation must be safe for concurrent use.
+type RateLimitStore interface {
+	// Allow takes one token from bucket by key. If bucket is empty, it returns false and duration to wait for token.
+	Allow(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// RateLimitOptions configures WithRateLimit.
+type RateLimitOptions struct {
+	// Store is a token buckets store. Default is NewMemoryRateLimitStore().
+	Store RateLimitStore
+
+	// Key returns client key. Default is RateLimitByIP.
+	Key RateLimitKeyFunc
+
+	// Limits are token bucket limits by `namespace.method`, `namespace` or `*` keys. The most specific limit is used.
+	// Methods of namespace share one bucket if limit is set for namespace.
+	Limits map[string]RateLimit
+}
+
+// RateLimitByIP uses IP from context as client key.
+func RateLimitByIP(ctx context.Context) string {
+	return appkit.IPFromContext(ctx)
+}
+
+// RateLimitByPlatform uses platform from context as client key.
+func RateLimitByPlatform(ctx context.Context) string {
+	return appkit.PlatformFromContext(ctx)
+}
+
+// RateLimitByClient uses IP, platform, version and User-Agent from context as client key.
+func RateLimitByClient(ctx context.Context) string {
+	return appkit.IPFromContext(ctx) + "|" + appkit.PlatformFromContext(ctx) + "|" +
+		appkit.VersionFromContext(ctx) + "|" + appkit.UserAgentFromContext(ctx)
+}
+
+// WithRateLimit limits requests by client key with token buckets for methods and namespaces.
+// It returns CodeRateLimited error with `RetryAfter` (ms) field in `extensions` if limit is exceeded.
+// Requests are allowed if store returns error.
+func WithRateLimit(opts RateLimitOptions) zenrpc.MiddlewareFunc {
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+
+	if opts.Key == nil {
+		opts.Key = RateLimitByIP
+	}
+
+	opts.Limits = lowerKeys(opts.Limits)
+	for key, l := range opts.Limits {
+		if l.Burst <= 0 {
+			l.Burst = max(1, int(math.Ceil(l.Rate)))
+		}
+		opts.Limits[key] = l
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+
+			limitKey, ok := methodKey(opts.Limits, namespace, method)
+			if !ok || opts.Limits[limitKey].Rate <= 0 {
+				return h(ctx, method, params)
+			}
+
+			key := opts.Key(ctx)
+			if key == "" {
+				return h(ctx, method, params)
+			}
+
+			allowed, retryAfter, err := opts.Store.Allow(ctx, limitKey+"|"+key, opts.Limits[limitKey])
+			if err != nil || allowed {
+				return h(ctx, method, params)
+			}
+
+			r := zenrpc.NewResponseError(nil, CodeRateLimited, "Too many requests", nil)
+			r.Extensions = map[string]interface{}{"RetryAfter": retryAfter.Milliseconds()}
+
+			return r
+		}
+	}
+}
+
+// memoryRateLimitStore is an in-memory RateLimitStore.
+type memoryRateLimitStore struct {
+	buckets     map[string]*tokenBucket
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// NewMemoryRateLimitStore returns in-memory RateLimitStore. Full buckets are removed periodically.
+func NewMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *memoryRateLimitStore) Allow(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanup(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		s.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	if limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64), nil
+	}
+
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+// cleanup removes full buckets once a minute.
+func (s *memoryRateLimitStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < time.Minute {
+		return
+	}
+
+	s.lastCleanup = now
+	for key, b := range s.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// refill adds tokens to bucket for elapsed time.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
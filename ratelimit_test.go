@@ -0,0 +1,58 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithRateLimit(middleware.RateLimitOptions{
+			Key: func(context.Context) string { return "client" },
+			Limits: map[string]middleware.RateLimit{
+				"arith.divide": {Rate: 0.5}, // burst is 1 by default
+				"*":            {Rate: 0},   // disabled
+			},
+		}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		method, out string
+	}{
+		{"arith.divide", `{"jsonrpc":"2.0","id":1,"result":{"Quo":0,"rem":1}}`},
+		{"arith.divide", `{"jsonrpc":"2.0","id":1,"error":{"code":429,"message":"Too many requests"},"extensions":{"RetryAfter":`},
+		{"arith.multiply", `{"jsonrpc":"2.0","id":1,"result":24}`},
+		{"arith.multiply", `{"jsonrpc":"2.0","id":1,"result":24}`},
+	} {
+		in := `{"jsonrpc": "2.0", "method": "` + c.method + `", "params": { "a": 1, "b": 24 }, "id": 1 }`
+		res, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(resp), c.out) {
+			t.Errorf("%d: got %s expected %s", i, resp, c.out)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// DefaultRecordMaskedHeaders are headers with credentials which are always masked by WithRecorder.
+//
+//nolint:gochecknoglobals // default list
+var DefaultRecordMaskedHeaders = []string{"Authorization", "Cookie", DefaultAPIKeyHeader, HeaderSignature}
+
+// RecordFilter configures WithRecorder.
+type RecordFilter struct {
+	// Rates are fractions of recorded calls by `namespace.method`, `namespace` or `*` keys: 0 – none, 0.1 – 10%, 1 – all.
+	// The most specific rate is used, methods without rate are not recorded. All calls are recorded if Rates is empty.
+	Rates map[string]float64
+
+	// Headers are request headers to record, other headers are skipped.
+	Headers []string
+
+	// MaskHeaders are headers with masked values in addition to DefaultRecordMaskedHeaders.
+	MaskHeaders []string
+
+	// Errors records all calls with errors regardless of rate.
+	Errors bool
+}
+
+// Record is a recorded call. It is written as one JSON line by WithRecorder.
+type Record struct {
+	Time       time.Time         `json:"time"`
+	Namespace  string            `json:"namespace"`
+	Method     string            `json:"method"`
+	Params     json.RawMessage   `json:"params,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Context    RecordContext     `json:"context"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Error      *zenrpc.Error     `json:"error,omitempty"`
+	DurationMS float64           `json:"durationMs"`
+}
+
+// RecordContext is a set of appkit context values of recorded call.
+type RecordContext struct {
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Country    string `json:"country,omitempty"`
+	XRequestID string `json:"xRequestId,omitempty"`
+	Principal  string `json:"principal,omitempty"`
+}
+
+// WithRecorder writes calls to w as JSON lines (see Record) for replay and regression testing.
+// Params and result are redacted by Redactor from context (see WithRedactor), values of DefaultRecordMaskedHeaders and
+// MaskHeaders are masked. Principal is recorded if WithHeaders is used before WithRecorder and auth middleware.
+// Writes are serialized, write errors are ignored.
+func WithRecorder(w io.Writer, filter RecordFilter) zenrpc.MiddlewareFunc {
+	filter.Rates = lowerKeys(filter.Rates)
+
+	masked := make(map[string]bool, len(DefaultRecordMaskedHeaders)+len(filter.MaskHeaders))
+	for _, name := range slices.Concat(DefaultRecordMaskedHeaders, filter.MaskHeaders) {
+		masked[http.CanonicalHeaderKey(name)] = true
+	}
+
+	var mu sync.Mutex
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			start := time.Now()
+			r := h(ctx, method, params)
+			t := time.Since(start)
+
+			if !filter.sampled(zenrpc.NamespaceFromContext(ctx), method, r) {
+				return r
+			}
+
+			data, err := json.Marshal(newRecord(ctx, start, method, params, r, t, filter.Headers, masked))
+			if err != nil {
+				return r
+			}
+
+			mu.Lock()
+			_, _ = w.Write(append(data, '\n'))
+			mu.Unlock()
+
+			return r
+		}
+	}
+}
+
+// sampled checks sample rate for call.
+func (f RecordFilter) sampled(namespace, method string, r zenrpc.Response) bool {
+	if len(f.Rates) == 0 || (f.Errors && r.Error != nil) {
+		return true
+	}
+
+	rate, ok := methodValue(f.Rates, namespace, method)
+	return ok && rate > 0 && (rate >= 1 || rand.Float64() < rate) //nolint:gosec // sampling
+}
+
+// newRecord returns Record for call.
+func newRecord(ctx context.Context, start time.Time, method string, params json.RawMessage, r zenrpc.Response, t time.Duration, headers []string, masked map[string]bool) Record {
+	rec := Record{
+		Time:      start,
+		Namespace: zenrpc.NamespaceFromContext(ctx),
+		Method:    method,
+		Params:    redactParams(ctx, method, params),
+		Context: RecordContext{
+			IP:         appkit.IPFromContext(ctx),
+			UserAgent:  appkit.UserAgentFromContext(ctx),
+			Platform:   appkit.PlatformFromContext(ctx),
+			Version:    appkit.VersionFromContext(ctx),
+			Country:    appkit.CountryFromContext(ctx),
+			XRequestID: appkit.XRequestIDFromContext(ctx),
+		},
+		Error:      r.Error,
+		DurationMS: float64(t.Microseconds()) / 1000,
+	}
+
+	if p := PrincipalFromContext(ctx); p != nil {
+		rec.Context.Principal = p.ID
+	}
+
+	if r.Result != nil {
+		rec.Result = redactResult(ctx, method, *r.Result)
+	}
+
+	mask := DefaultRedactMask
+	if rd := RedactorFromContext(ctx); rd != nil {
+		mask = rd.mask
+	}
+
+	if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+		for _, name := range headers {
+			v := req.Header.Get(name)
+			if v == "" {
+				continue
+			}
+
+			name = http.CanonicalHeaderKey(name)
+			if masked[name] {
+				v = mask
+			}
+
+			if rec.Headers == nil {
+				rec.Headers = make(map[string]string, len(headers))
+			}
+			rec.Headers[name] = v
+		}
+	}
+
+	return rec
+}
+
+// ReadRecords reads records written by WithRecorder.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var rr []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+
+		rr = append(rr, rec)
+	}
+
+	return rr, sc.Err()
+}
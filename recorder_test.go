@@ -0,0 +1,75 @@
+package middleware_test
+
+import (
+	"bytes"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+)
+
+func TestMiddlewareRecorder(t *testing.T) {
+	var buf bytes.Buffer
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithHeaders(),
+		middleware.WithRedactor(middleware.NewRedactor(middleware.RedactOptions{Rules: []string{"b"}})),
+		middleware.WithRecorder(&buf, middleware.RecordFilter{
+			Rates:       map[string]float64{"arith.multiply": 1, "arith": 0},
+			Headers:     []string{"platform", "Authorization", "x-api-key", "X-Device-Id"},
+			MaskHeaders: []string{"x-device-id"},
+			Errors:      true,
+		}),
+		middleware.WithAPIKeyAuth(middleware.StaticKeyStore{"secret": {ID: "app", Scopes: []string{"*"}}}, middleware.APIKeyOptions{}),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	for _, in := range []string{
+		`{"jsonrpc": "2.0", "method": "arith.multiply", "params": {"a": 3, "b": 2}, "id": 1}`,
+		`{"jsonrpc": "2.0", "method": "arith.divide", "params": {"a": 1, "b": 2}, "id": 2}`,
+		`{"jsonrpc": "2.0", "method": "arith.divide", "params": {"a": 1, "b": 0}, "id": 3}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Platform", "ios")
+		req.Header.Set("Version", "1.2.3")
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Device-Id", "device")
+		req.Header.Set(middleware.DefaultAPIKeyHeader, "secret")
+		rpc.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr, err := middleware.ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rr) != 2 {
+		t.Fatalf("got %d records, expected 2", len(rr))
+	}
+
+	rec := rr[0]
+	if rec.Namespace != "arith" || rec.Method != "multiply" || string(rec.Params) != `{"a":3,"b":"***"}` || string(rec.Result) != "6" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	expected := map[string]string{"Platform": "ios", "Authorization": "***", "X-Api-Key": "***", "X-Device-Id": "***"}
+	if !maps.Equal(rec.Headers, expected) {
+		t.Errorf("got headers %v, expected %v", rec.Headers, expected)
+	}
+
+	if c := rec.Context; c.Platform != "ios" || c.Version != "1.2.3" || c.Principal != "app" {
+		t.Errorf("unexpected context: %+v", c)
+	}
+
+	if rec = rr[1]; rec.Method != "divide" || rec.Error == nil || rec.Error.Message != "divide by zero" || rec.Result != nil {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
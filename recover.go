@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// CodePanic is a JSON-RPC error code for recovered panics.
+const CodePanic = zenrpc.InternalError
+
+// RecoverOptions configures WithRecover.
+type RecoverOptions struct {
+	// ServerName is used in logs and metrics.
+	ServerName string
+
+	// Printf logs panic with stack trace.
+	Printf Printf
+
+	// Print logs panic with stack trace via slog, e.g. slog.ErrorContext.
+	Print Print
+
+	// Sentry enables panic reporting to Sentry hub from context, if present.
+	Sentry bool
+
+	// Registerer is used for metrics registration. Default is prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// WithRecover recovers panics and returns CodePanic error with "Internal error" message.
+// Panic is logged with stack trace via Printf and Print funcs and is reported to Sentry if enabled.
+// It exposes metric `app_rpc_panics_total` with labels: method, server.
+// It should be the last middleware to catch panics only from method.
+func WithRecover(opts RecoverOptions) zenrpc.MiddlewareFunc {
+	report := newPanicReporter(opts)
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) (r zenrpc.Response) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+
+				stack := debug.Stack()
+				if gp, ok := rec.(*goroutinePanic); ok {
+					rec, stack = gp.value, gp.stack
+				}
+
+				report(ctx, method, params, rec, stack)
+				r = zenrpc.NewResponseError(nil, CodePanic, "Internal error", nil)
+			}()
+
+			return h(ctx, method, params)
+		}
+	}
+}
+
+// panicReporter logs, counts and reports panic to Sentry.
+type panicReporter func(ctx context.Context, method string, params json.RawMessage, rec any, stack []byte)
+
+func newPanicReporter(opts RecoverOptions) panicReporter {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	panics := registerCollector(opts.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: defaultMetricsNamespace,
+		Subsystem: defaultMetricsSubsystem,
+		Name:      "panics_total",
+		Help:      "Recovered panics count by method.",
+	}, []string{"method", "server"}))
+
+	return func(ctx context.Context, method string, params json.RawMessage, rec any, stack []byte) {
+		namespace := zenrpc.NamespaceFromContext(ctx)
+		panics.WithLabelValues(namespace+"."+method, opts.ServerName).Inc()
+
+		err, ok := rec.(error)
+		if !ok {
+			err = fmt.Errorf("%v", rec)
+		}
+
+		methodName := fullMethodName(opts.ServerName, namespace, method)
+		params = redactParams(ctx, method, params)
+
+		if opts.Printf != nil {
+			opts.Printf("panic ip=%s platform=%q version=%q method=%s params=%s xRequestId=%q err=%q\n%s",
+				appkit.IPFromContext(ctx), appkit.PlatformFromContext(ctx), appkit.VersionFromContext(ctx),
+				methodName, params, appkit.XRequestIDFromContext(ctx), err, stack)
+		}
+
+		if opts.Print != nil {
+			opts.Print(ctx, "rpc panic", append(additionalArgs(ctx),
+				"method", methodName,
+				"params", params,
+				"err", err,
+				"xRequestId", appkit.XRequestIDFromContext(ctx),
+				"stack", string(stack),
+			)...)
+		}
+
+		if hub := sentry.GetHubFromContext(ctx); opts.Sentry && hub != nil {
+			hub.WithScope(func(scope *sentry.Scope) {
+				scope.SetExtra("params", params)
+				scope.SetTag("method", methodName)
+				hub.RecoverWithContext(ctx, rec)
+			})
+		}
+	}
+}
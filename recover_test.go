@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	reg := prometheus.NewRegistry()
+
+	mw := middleware.WithRecover(middleware.RecoverOptions{
+		ServerName: "test",
+		Printf:     log.New(&buf, "", 0).Printf,
+		Registerer: reg,
+	})
+
+	invoke := mw(func(_ context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		if method == "panic" {
+			panic("boom")
+		}
+
+		return zenrpc.Response{}
+	})
+
+	if r := invoke(t.Context(), "ok", nil); r.Error != nil {
+		t.Errorf("got error %+v", r.Error)
+	}
+
+	r := invoke(t.Context(), "panic", nil)
+	if r.Error == nil || r.Error.Code != middleware.CodePanic || r.Error.Message != "Internal error" {
+		t.Errorf("got %+v, expected panic error", r.Error)
+	}
+
+	if out := buf.String(); !strings.Contains(out, `method=test..panic`) || !strings.Contains(out, "recover_test.go") {
+		t.Errorf("unexpected log: %s", out)
+	}
+
+	expected := `
+# HELP app_rpc_panics_total Recovered panics count by method.
+# TYPE app_rpc_panics_total counter
+app_rpc_panics_total{method=".panic",server="test"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_rpc_panics_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,342 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+type redactorKey struct{}
+
+// DefaultRedactMask is a default replacement for redacted values.
+const DefaultRedactMask = "***"
+
+// RedactOptions configures Redactor.
+type RedactOptions struct {
+	// Rules are applied to params of all methods. Rule is a key name (e.g. `password`), which matches key on any depth,
+	// or a path of keys separated by dot (e.g. `auth.token`, `*.password`), which matches from params root.
+	// `*` in path matches any key or array index, number matches array index (for positional params).
+	// Keys are case-insensitive.
+	Rules []string
+
+	// MethodRules are rules for specific methods in `namespace.method` format.
+	MethodRules map[string][]string
+
+	// SQL enables masking of literals in formatted SQL queries (e.g. `WHERE password = '***' AND pin = ***`):
+	// string constants (including E'...' and dollar-quoted) and numbers. Identifiers, keywords, NULL, booleans
+	// and comments are kept.
+	SQL bool
+
+	// Mask is a replacement for redacted values. Default is DefaultRedactMask.
+	Mask string
+}
+
+// Redactor rewrites sensitive values in params and SQL queries before they reach logs, Sentry, traces, etc.
+type Redactor struct {
+	rules       []redactRule
+	methodRules map[string][]redactRule
+	sql         bool
+	mask        string
+}
+
+// redactRule is a parsed rule: single key for any depth or path from root.
+type redactRule struct {
+	path    []string
+	anyPath bool
+}
+
+// NewRedactor returns new Redactor.
+func NewRedactor(opts RedactOptions) *Redactor {
+	r := &Redactor{
+		rules:       parseRedactRules(opts.Rules),
+		methodRules: make(map[string][]redactRule, len(opts.MethodRules)),
+		sql:         opts.SQL,
+		mask:        opts.Mask,
+	}
+
+	if r.mask == "" {
+		r.mask = DefaultRedactMask
+	}
+
+	for method, rules := range opts.MethodRules {
+		r.methodRules[strings.ToLower(method)] = parseRedactRules(rules)
+	}
+
+	return r
+}
+
+func parseRedactRules(rules []string) []redactRule {
+	rr := make([]redactRule, 0, len(rules))
+	for _, rule := range rules {
+		path := strings.Split(strings.ToLower(rule), ".")
+		_, err := strconv.Atoi(path[0])
+		rr = append(rr, redactRule{path: path, anyPath: len(path) == 1 && path[0] != "*" && err != nil})
+	}
+
+	return rr
+}
+
+// WithRedactor sets Redactor to context. It must be set before WithAPILogger, WithSLog, WithErrorLogger,
+// WithErrorSLog, WithSentry and WithSQLLogger.
+func WithRedactor(r *Redactor) zenrpc.MiddlewareFunc {
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			return h(NewRedactorContext(ctx, r), method, params)
+		}
+	}
+}
+
+// NewRedactorContext creates new context with Redactor.
+func NewRedactorContext(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, redactorKey{}, r)
+}
+
+// RedactorFromContext returns Redactor from context.
+func RedactorFromContext(ctx context.Context) *Redactor {
+	r, _ := ctx.Value(redactorKey{}).(*Redactor)
+	return r
+}
+
+// Params returns params with redacted values for method in `namespace.method` format.
+// Original params are returned if nothing was redacted or params are not valid JSON.
+func (r *Redactor) Params(method string, params json.RawMessage) json.RawMessage {
+	rules := r.rules
+	if mr, ok := r.methodRules[strings.ToLower(method)]; ok {
+		rules = append(rules[:len(rules):len(rules)], mr...)
+	}
+
+	if len(rules) == 0 || len(params) == 0 {
+		return params
+	}
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return params
+	}
+
+	changed := false
+	for _, rule := range rules {
+		if r.redact(v, nil, rule) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return params
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return params
+	}
+
+	return b
+}
+
+// Result returns marshalled response result with redacted values. Rules are the same as for params.
+func (r *Redactor) Result(method string, result json.RawMessage) json.RawMessage {
+	return r.Params(method, result)
+}
+
+// SQL returns query with masked string and numeric literals if SQL masking is enabled.
+func (r *Redactor) SQL(query string) string {
+	if !r.sql {
+		return query
+	}
+
+	return maskSQLLiterals(query, r.mask)
+}
+
+// redact walks through v and replaces values matched by rule. Path is a current path from root.
+func (r *Redactor) redact(v any, path []string, rule redactRule) bool {
+	changed := false
+	switch vv := v.(type) {
+	case map[string]any:
+		for k := range vv {
+			p := append(path[:len(path):len(path)], strings.ToLower(k))
+			if rule.match(p) {
+				vv[k], changed = r.mask, true
+				continue
+			}
+
+			if r.redact(vv[k], p, rule) {
+				changed = true
+			}
+		}
+	case []any:
+		for i := range vv {
+			p := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if rule.match(p) {
+				vv[i], changed = r.mask, true
+				continue
+			}
+
+			if r.redact(vv[i], p, rule) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// match checks rule for current path.
+func (rr redactRule) match(path []string) bool {
+	if rr.anyPath {
+		return path[len(path)-1] == rr.path[0]
+	}
+
+	if len(path) != len(rr.path) {
+		return false
+	}
+
+	for i := range path {
+		if rr.path[i] != "*" && rr.path[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// redactParams returns params redacted by Redactor from context.
+func redactParams(ctx context.Context, method string, params json.RawMessage) json.RawMessage {
+	r := RedactorFromContext(ctx)
+	if r == nil {
+		return params
+	}
+
+	return r.Params(zenrpc.NamespaceFromContext(ctx)+"."+method, params)
+}
+
+// redactResult returns result redacted by Redactor from context.
+func redactResult(ctx context.Context, method string, result json.RawMessage) json.RawMessage {
+	r := RedactorFromContext(ctx)
+	if r == nil {
+		return result
+	}
+
+	return r.Result(zenrpc.NamespaceFromContext(ctx)+"."+method, result)
+}
+
+// redactSQL returns query redacted by Redactor from context.
+func redactSQL(ctx context.Context, query string) string {
+	r := RedactorFromContext(ctx)
+	if r == nil {
+		return query
+	}
+
+	return r.SQL(query)
+}
+
+// maskSQLLiterals replaces string constants with quoted mask and numbers with mask.
+func maskSQLLiterals(query, mask string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+		prevIdent := i > 0 && isSQLIdentChar(query[i-1])
+
+		switch {
+		case c == '"':
+			// quoted identifier
+			i = sqlQuotedEnd(query, i, '"', false)
+			b.WriteString(query[start:i])
+		case strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+			b.WriteString(query[start:i])
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+			b.WriteString(query[start:i])
+		case c == '\'':
+			// E'...' supports backslash escapes
+			escapes := i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isSQLIdentChar(query[i-2]))
+			i = sqlQuotedEnd(query, i, '\'', escapes)
+			b.WriteString("'" + mask + "'")
+		case c == '$' && !prevIdent && sqlDollarTag(query[i:]) != "":
+			tag := sqlDollarTag(query[i:])
+			if end := strings.Index(query[i+len(tag):], tag); end >= 0 {
+				i += 2*len(tag) + end
+			} else {
+				i = len(query)
+			}
+			b.WriteString("'" + mask + "'")
+		case !prevIdent && (isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1]))):
+			i = sqlNumberEnd(query, i)
+			b.WriteString(mask)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// sqlQuotedEnd returns index after closing quote q of literal started at i. Doubled quotes are escaped.
+func sqlQuotedEnd(query string, i int, q byte, backslashEscapes bool) int {
+	for i++; i < len(query); i++ {
+		switch {
+		case backslashEscapes && query[i] == '\\':
+			i++
+		case query[i] == q && i+1 < len(query) && query[i+1] == q:
+			i++
+		case query[i] == q:
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+// sqlDollarTag returns dollar quote tag (e.g. `$$` or `$tag$`) at the beginning of s. Positional params like `$1`
+// are not tags.
+func sqlDollarTag(s string) string {
+	j := 1
+	for j < len(s) && s[j] != '$' && isSQLIdentChar(s[j]) {
+		j++
+	}
+
+	if j == len(s) || s[j] != '$' || (j > 1 && isDigit(s[1])) {
+		return ""
+	}
+
+	return s[:j+1]
+}
+
+// sqlNumberEnd returns index after number started at i, including decimals, exponent and hex/binary notation.
+func sqlNumberEnd(query string, i int) int {
+	for i++; i < len(query); i++ {
+		c := query[i]
+		isSign := (c == '+' || c == '-') && (query[i-1] == 'e' || query[i-1] == 'E')
+		if !isSign && c != '.' && !isSQLIdentChar(c) {
+			break
+		}
+	}
+
+	return i
+}
+
+func isSQLIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
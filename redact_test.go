@@ -0,0 +1,68 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+)
+
+func TestRedactor(t *testing.T) {
+	r := middleware.NewRedactor(middleware.RedactOptions{
+		Rules: []string{"password", "auth.token", "*.card"},
+		MethodRules: map[string][]string{
+			"auth.login": {"1"},
+		},
+		SQL: true,
+	})
+
+	tcs := []struct {
+		method, in, out string
+	}{
+		{
+			method: "user.update",
+			in:     `{"user": {"login": "john", "Password": "secret"}, "passwords": [1, 2]}`,
+			out:    `{"passwords":[1,2],"user":{"Password":"***","login":"john"}}`,
+		},
+		{
+			method: "user.pay",
+			in:     `{"auth": {"token": "abc", "user": 1}, "payment": {"card": "4111111111111111", "sum": 10.50}, "card": "1"}`,
+			out:    `{"auth":{"token":"***","user":1},"card":"1","payment":{"card":"***","sum":10.50}}`,
+		},
+		{
+			method: "auth.login",
+			in:     `["john", "secret"]`,
+			out:    `["john","***"]`,
+		},
+		{
+			method: "auth.logout",
+			in:     `["john", "secret"]`,
+			out:    `["john", "secret"]`,
+		},
+		{
+			method: "user.get",
+			in:     `{ "id": 1 }`,
+			out:    `{ "id": 1 }`,
+		},
+	}
+
+	for _, tc := range tcs {
+		if got := r.Params(tc.method, json.RawMessage(tc.in)); string(got) != tc.out {
+			t.Errorf("%s: got %s, expected %s", tc.method, got, tc.out)
+		}
+	}
+
+	for q, expected := range map[string]string{
+		`SELECT * FROM users WHERE login = 'john' AND password = 'it''s secret' LIMIT 1`:    `SELECT * FROM users WHERE login = '***' AND password = '***' LIMIT ***`,
+		`UPDATE "cards2" SET "pan" = 4111111111111111, "pin" = '1234' WHERE "t1"."id" = -5`: `UPDATE "cards2" SET "pan" = ***, "pin" = '***' WHERE "t1"."id" = -***`,
+		`SELECT 1.5e-3, .5, 0x1F, col1::int4 FROM t2`:                                       `SELECT ***, ***, ***, col1::int4 FROM t2`,
+		`SELECT E'it\'s ' || 'secret', e'\\' FROM t`:                                        `SELECT E'***' || '***', e'***' FROM t`,
+		`SELECT $$it's secret$$, $tag$ $$ 'secret' $tag$, $1`:                               `SELECT '***', '***', $1`,
+		`SELECT "it's" FROM t -- it's comment`:                                              `SELECT "it's" FROM t -- it's comment`,
+		`SELECT /* it's 1 */ true, NULL`:                                                    `SELECT /* it's 1 */ true, NULL`,
+	} {
+		if got := r.SQL(q); got != expected {
+			t.Errorf("got %s, expected %s", got, expected)
+		}
+	}
+}
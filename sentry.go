@@ -36,7 +36,7 @@ func WithSentry(serverName string) zenrpc.MiddlewareFunc {
 					methodName := fullMethodName(serverName, zenrpc.NamespaceFromContext(ctx), method)
 
 					hub.Scope().SetExtras(map[string]interface{}{
-						"params":   params,
+						"params":   redactParams(ctx, method, params),
 						"duration": time.Since(start).String(),
 						"ip":       ip,
 					})
@@ -72,6 +72,7 @@ func WithErrorLogger(pf Printf, serverName string) zenrpc.MiddlewareFunc {
 				duration := time.Since(start)
 				methodName := fullMethodName(serverName, namespace, method)
 
+				params = redactParams(ctx, method, params)
 				pf("ip=%s platform=%q version=%q method=%s duration=%v params=%s xRequestId=%q err=%q", ip, platform, version, methodName, duration, params, xRequestID, r.Error)
 
 				// initialize hub and scope
@@ -132,6 +133,7 @@ func WithErrorSLog(pf Print, serverName string, fn LogAttrs) zenrpc.MiddlewareFu
 
 				duration := time.Since(start)
 				methodName := fullMethodName(serverName, namespace, method)
+				params = redactParams(ctx, method, params)
 
 				t := time.Since(start)
 				logArgs := append(additionalArgs(ctx), []any{
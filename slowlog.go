@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// WithSlowLog logs via slog function (e.g. slog.WarnContext) requests slower than threshold. Thresholds for
+// specific methods could be set via perMethod by `namespace.method` or `namespace` keys.
+// Log args are the same as in WithSLog plus `threshold`, `durationSQL` and `sql` with all SQL queries of request.
+// SQL queries are captured for every request if db is set.
+func WithSlowLog(threshold time.Duration, perMethod map[string]time.Duration, pf Print, serverName string, db *pg.DB) zenrpc.MiddlewareFunc {
+	perMethod = lowerKeys(perMethod)
+
+	var ql *sqlQueryLogger
+	if db != nil {
+		ql = newSQLCaptureLogger()
+		db.AddQueryHook(ql)
+	}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			budget := threshold
+			if v, ok := methodValue(perMethod, namespace, method); ok {
+				budget = v
+			}
+
+			if budget <= 0 {
+				return h(ctx, method, params)
+			}
+
+			var captureID uint64
+			if ql != nil {
+				captureID = ql.NextID()
+				ql.Push(captureID)
+				defer ql.Pop(captureID) // queries are removed on panic too
+				ctx = ql.newCaptureContext(ctx, captureID)
+			}
+
+			start := time.Now()
+			r := h(ctx, method, params)
+			t := time.Since(start)
+
+			var qq []sqlQuery
+			if ql != nil {
+				qq = ql.Pop(captureID)
+			}
+
+			if t < budget {
+				return r
+			}
+
+			logArgs := append(rpcLogArgs(ctx, serverName, method, params, r, t), "threshold", budget.String())
+			if ql != nil {
+				var totalSQL time.Duration
+				for i := range qq {
+					totalSQL += qq[i].Duration.Duration
+				}
+
+				logArgs = append(logArgs, "durationSQL", totalSQL.Milliseconds(), "sql", qq)
+			}
+
+			pf(ctx, "rpc slow", logArgs...)
+			return r
+		}
+	}
+}
@@ -152,6 +152,14 @@ type sqlQueryLogger struct {
 	nextID uint64
 	data   map[uint64][]sqlQuery
 	dataMu *sync.Mutex
+
+	// capture is set for loggers, that use own capture ID from context instead of debug ID.
+	capture bool
+}
+
+// sqlCaptureKey is a context key for capture ID of sqlQueryLogger.
+type sqlCaptureKey struct {
+	ql *sqlQueryLogger
 }
 
 type sqlQuery struct {
@@ -175,12 +183,34 @@ func NewSQLQueryLogger() *sqlQueryLogger {
 	}
 }
 
+// newSQLCaptureLogger returns sqlQueryLogger, that captures queries for ID set by newCaptureContext.
+func newSQLCaptureLogger() *sqlQueryLogger {
+	ql := NewSQLQueryLogger()
+	ql.capture = true
+	return ql
+}
+
+// newCaptureContext creates new context with capture ID for sqlQueryLogger created by newSQLCaptureLogger.
+func (ql *sqlQueryLogger) newCaptureContext(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, sqlCaptureKey{ql: ql}, id)
+}
+
+// queryID returns capture ID or debug ID from context.
+func (ql *sqlQueryLogger) queryID(ctx context.Context) uint64 {
+	if ql.capture {
+		id, _ := ctx.Value(sqlCaptureKey{ql: ql}).(uint64)
+		return id
+	}
+
+	return appkit.DebugIDFromContext(ctx)
+}
+
 func (ql *sqlQueryLogger) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
 	if event.Stash == nil {
 		event.Stash = make(map[interface{}]interface{})
 	}
 
-	if appkit.DebugIDFromContext(ctx) != appkit.EmptyDebugID {
+	if ql.queryID(ctx) != appkit.EmptyDebugID {
 		event.Stash[eventStartedAt] = time.Now()
 	}
 
@@ -188,7 +218,7 @@ func (ql *sqlQueryLogger) BeforeQuery(ctx context.Context, event *pg.QueryEvent)
 }
 
 func (ql *sqlQueryLogger) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
-	debugID := appkit.DebugIDFromContext(ctx)
+	debugID := ql.queryID(ctx)
 	if debugID == appkit.EmptyDebugID {
 		return nil
 	}
@@ -198,7 +228,7 @@ func (ql *sqlQueryLogger) AfterQuery(ctx context.Context, event *pg.QueryEvent)
 	if err != nil {
 		return fmt.Errorf("formatted query failed: %w", err)
 	}
-	sq := sqlQuery{Query: string(query)}
+	sq := sqlQuery{Query: redactSQL(ctx, string(query))}
 
 	// calculate duration
 	if event.Stash != nil {
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/vmkteam/appkit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	eventSpan = "querySpan"
+)
+
+// SQLStatementMode sets how SQL statement is captured in `db.statement` span attribute.
+type SQLStatementMode int
+
+const (
+	// SQLStatementOff disables statement capturing.
+	SQLStatementOff SQLStatementMode = iota
+	// SQLStatementNormalized captures statement without params, e.g. `SELECT * FROM users WHERE id = ?`.
+	SQLStatementNormalized
+	// SQLStatementFull captures formatted statement with params.
+	SQLStatementFull
+)
+
+type sqlQueryTracer struct {
+	tracer trace.Tracer
+	mode   SQLStatementMode
+}
+
+// NewSQLQueryTracer returns go-pg query hook that creates child span for every SQL query, if current context
+// has a valid span (e.g. it was started by WithTracing). Attributes: db.system, db.operation, db.statement,
+// db.rows_affected, db.sql_group (from appkit.SQLGroupFromContext). If tp is nil, global tracer provider is used.
+//
+//	db.AddQueryHook(middleware.NewSQLQueryTracer(tp, middleware.SQLStatementNormalized))
+func NewSQLQueryTracer(tp trace.TracerProvider, mode SQLStatementMode) *sqlQueryTracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return &sqlQueryTracer{
+		tracer: tp.Tracer(tracerName),
+		mode:   mode,
+	}
+}
+
+func (qt *sqlQueryTracer) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+
+	if event.Stash == nil {
+		event.Stash = make(map[interface{}]interface{})
+	}
+
+	query, _ := event.UnformattedQuery()
+	operation := sqlOperation(query)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+	}
+
+	if group := strings.Trim(appkit.SQLGroupFromContext(ctx), ">"); group != "" {
+		attrs = append(attrs, attribute.String("db.sql_group", group))
+	}
+
+	ctx, span := qt.tracer.Start(ctx, operation, //nolint:spancheck // span is ended in AfterQuery
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(event.StartTime),
+		trace.WithAttributes(attrs...),
+	)
+	event.Stash[eventSpan] = span
+
+	return ctx, nil
+}
+
+func (qt *sqlQueryTracer) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	if event.Stash == nil {
+		return nil
+	}
+
+	span, ok := event.Stash[eventSpan].(trace.Span)
+	if !ok {
+		return nil
+	}
+	defer span.End()
+
+	if statement := qt.statement(event); statement != "" {
+		span.SetAttributes(attribute.String("db.statement", redactSQL(ctx, statement)))
+	}
+
+	if event.Result != nil {
+		span.SetAttributes(attribute.Int("db.rows_affected", event.Result.RowsAffected()))
+	}
+
+	if event.Err != nil && !errors.Is(event.Err, pg.ErrNoRows) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+
+	return nil
+}
+
+// statement returns SQL statement according to capture mode.
+func (qt *sqlQueryTracer) statement(event *pg.QueryEvent) string {
+	switch qt.mode {
+	case SQLStatementOff:
+		return ""
+	case SQLStatementFull:
+		if query, _ := event.FormattedQuery(); len(query) > 0 {
+			return string(query)
+		}
+	case SQLStatementNormalized:
+	}
+
+	query, _ := event.UnformattedQuery()
+	return string(query)
+}
+
+// sqlOperation returns first keyword of SQL query in upper case, e.g. SELECT.
+func sqlOperation(query []byte) string {
+	query = bytes.TrimSpace(query)
+	if i := bytes.IndexAny(query, " \t\r\n("); i > 0 {
+		query = query[:i]
+	}
+
+	if len(query) == 0 {
+		return "QUERY"
+	}
+
+	return strings.ToUpper(string(query))
+}
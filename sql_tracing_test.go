@@ -0,0 +1,61 @@
+package middleware_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/vmkteam/appkit"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSQLQueryTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	qt := middleware.NewSQLQueryTracer(tp, middleware.SQLStatementNormalized)
+
+	query := func(ctx context.Context, q string) {
+		event := &pg.QueryEvent{Query: q}
+		ctx, err := qt.BeforeQuery(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = qt.AfterQuery(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// no parent span: query is skipped
+	query(t.Context(), "SELECT 1")
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("got %d spans, expected 0", n)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(t.Context(), "rpc")
+	query(appkit.NewSQLGroupContext(ctx, "users"), " select * from users where id = ?")
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(spans))
+	}
+
+	s := spans[0]
+	if s.Name != "SELECT" || s.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("got span name=%s parent=%s", s.Name, s.Parent.SpanID())
+	}
+
+	attrs := attribute.NewSet(s.Attributes...)
+	if v, _ := attrs.Value("db.statement"); v.AsString() != " select * from users where id = ?" {
+		t.Errorf("got db.statement=%q", v.AsString())
+	}
+
+	if v, _ := attrs.Value("db.sql_group"); v.AsString() != "users" {
+		t.Errorf("got db.sql_group=%q", v.AsString())
+	}
+}
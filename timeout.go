@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+// CodeTimeout is a JSON-RPC error code for requests exceeded deadline.
+const CodeTimeout = http.StatusGatewayTimeout
+
+type deadlineKey struct{}
+
+// NewDeadlineContext creates new context with deadline value. Unlike context.WithDeadline, value is kept in
+// context.WithoutCancel (e.g. after WithNoCancelContext).
+func NewDeadlineContext(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, deadlineKey{}, deadline)
+}
+
+// DeadlineFromContext returns deadline set by WithTimeout or context deadline.
+func DeadlineFromContext(ctx context.Context) (time.Time, bool) {
+	if d, ok := ctx.Value(deadlineKey{}).(time.Time); ok {
+		return d, true
+	}
+
+	return ctx.Deadline()
+}
+
+// TimeoutOptions configures WithTimeoutOpts.
+type TimeoutOptions struct {
+	// Timeout is a default timeout. Zero value disables deadline.
+	Timeout time.Duration
+
+	// PerMethod are timeouts by `namespace.method` or `namespace` keys.
+	PerMethod map[string]time.Duration
+
+	// Recover configures reporting of panics in methods finished after deadline, like in WithRecover.
+	// Panics are logged via log.Printf if Printf and Print are not set.
+	Recover RecoverOptions
+}
+
+// WithTimeout sets deadline for method execution: default timeout or timeout by `namespace.method` or `namespace`
+// keys from perMethod. Zero timeout disables deadline. If deadline is exceeded, CodeTimeout error is returned,
+// and method is still running in background. Context of method is cancelled by deadline, use WithNoCancelContext after
+// WithTimeout to ignore it (e.g. for go-pg). Deadline value is available via DeadlineFromContext in both cases.
+// Panics of methods finished after deadline are logged via log.Printf, use WithTimeoutOpts to configure it.
+func WithTimeout(timeout time.Duration, perMethod map[string]time.Duration) zenrpc.MiddlewareFunc {
+	return WithTimeoutOpts(TimeoutOptions{Timeout: timeout, PerMethod: perMethod})
+}
+
+// WithTimeoutOpts is the same as WithTimeout, but with configurable reporting of panics after deadline.
+// Panics before deadline are passed to caller, e.g. to WithRecover.
+func WithTimeoutOpts(opts TimeoutOptions) zenrpc.MiddlewareFunc {
+	timeout, perMethod := opts.Timeout, lowerKeys(opts.PerMethod)
+
+	if opts.Recover.Printf == nil && opts.Recover.Print == nil {
+		opts.Recover.Printf = log.Printf
+	}
+	reportLate := newPanicReporter(opts.Recover)
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			d := timeout
+			if v, ok := methodValue(perMethod, zenrpc.NamespaceFromContext(ctx), method); ok {
+				d = v
+			}
+
+			if d <= 0 {
+				return h(ctx, method, params)
+			}
+
+			deadline := time.Now().Add(d)
+			ctx, cancel := context.WithDeadline(NewDeadlineContext(ctx, deadline), deadline)
+
+			defer cancel()
+
+			type result struct {
+				r   zenrpc.Response
+				rec *goroutinePanic
+			}
+
+			// result is passed to caller until timedOut is closed, panic after deadline is reported by goroutine
+			done, timedOut := make(chan result), make(chan struct{})
+			go func() {
+				var res result
+				defer func() {
+					if rec := recover(); rec != nil {
+						res = result{rec: &goroutinePanic{value: rec, stack: debug.Stack()}}
+					}
+
+					select {
+					case done <- res:
+					case <-timedOut:
+						if res.rec != nil {
+							reportLate(ctx, method, params, res.rec.value, res.rec.stack)
+						}
+					}
+				}()
+
+				res.r = h(ctx, method, params)
+			}()
+
+			var res result
+			select {
+			case res = <-done:
+			case <-ctx.Done():
+				// method could finish at the same time as deadline
+				select {
+				case res = <-done:
+				default:
+					close(timedOut)
+					return zenrpc.NewResponseError(nil, CodeTimeout, "Timeout exceeded", nil)
+				}
+			}
+
+			if res.rec != nil {
+				panic(res.rec)
+			}
+
+			return res.r
+		}
+	}
+}
+
+// goroutinePanic is a panic recovered in method goroutine with its stack. It is unwrapped by WithRecover.
+type goroutinePanic struct {
+	value any
+	stack []byte
+}
+
+func (p *goroutinePanic) Error() string {
+	return fmt.Sprintf("%v\n\ngoroutine stack:\n%s", p.value, p.stack)
+}
+
+func (p *goroutinePanic) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
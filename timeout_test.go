@@ -0,0 +1,98 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmkteam/zenrpc/v2"
+)
+
+func TestTimeout(t *testing.T) {
+	finished := make(chan bool, 1)
+	h := func(ctx context.Context, method string, _ json.RawMessage) zenrpc.Response {
+		if _, ok := middleware.DeadlineFromContext(ctx); !ok {
+			t.Error("deadline must be set")
+		}
+
+		if method == "slow" {
+			time.Sleep(50 * time.Millisecond)
+			finished <- ctx.Err() == nil
+		}
+
+		return zenrpc.Response{}
+	}
+
+	mw := middleware.WithTimeout(time.Second, map[string]time.Duration{".Slow": 10 * time.Millisecond}) // keys are case-insensitive
+	invoke := mw(middleware.WithNoCancelContext()(h))
+
+	for range 100 {
+		if r := invoke(t.Context(), "fast", nil); r.Error != nil {
+			t.Fatalf("got error %+v", r.Error)
+		}
+	}
+
+	if r := invoke(t.Context(), "slow", nil); r.Error == nil || r.Error.Code != middleware.CodeTimeout {
+		t.Errorf("got %+v, expected timeout error", r.Error)
+	}
+
+	if !<-finished {
+		t.Error("slow method context must not be cancelled")
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	var buf bytes.Buffer
+	rec := middleware.WithRecover(middleware.RecoverOptions{
+		Printf:     log.New(&buf, "", 0).Printf,
+		Registerer: prometheus.NewRegistry(),
+	})
+
+	invoke := rec(middleware.WithTimeout(time.Second, nil)(panicHandler))
+	if r := invoke(t.Context(), "panic", nil); r.Error == nil || r.Error.Code != middleware.CodePanic {
+		t.Fatalf("got %+v, expected panic error", r.Error)
+	}
+
+	if out := buf.String(); !strings.Contains(out, `err="boom"`) || !strings.Contains(out, "panicHandler") {
+		t.Errorf("log must contain method stack: %s", out)
+	}
+}
+
+func TestTimeoutLatePanic(t *testing.T) {
+	logged := make(chan string, 1)
+	mw := middleware.WithTimeoutOpts(middleware.TimeoutOptions{
+		Timeout: 10 * time.Millisecond,
+		Recover: middleware.RecoverOptions{
+			Printf:     func(format string, v ...any) { logged <- fmt.Sprintf(format, v...) },
+			Registerer: prometheus.NewRegistry(),
+		},
+	})
+
+	timedOut := make(chan struct{})
+	invoke := mw(func(ctx context.Context, _ string, _ json.RawMessage) zenrpc.Response {
+		<-ctx.Done()
+		<-timedOut
+		panic("late boom")
+	})
+
+	if r := invoke(t.Context(), "late", nil); r.Error == nil || r.Error.Code != middleware.CodeTimeout {
+		t.Fatalf("got %+v, expected timeout error", r.Error)
+	}
+	close(timedOut)
+
+	if out := <-logged; !strings.Contains(out, "method=.late") || !strings.Contains(out, `err="late boom"`) {
+		t.Errorf("got %s, expected logged panic", out)
+	}
+}
+
+func panicHandler(context.Context, string, json.RawMessage) zenrpc.Response {
+	panic("boom")
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/vmkteam/appkit"
+	"github.com/vmkteam/zenrpc/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is an instrumentation scope name for OpenTelemetry.
+const tracerName = "github.com/vmkteam/zenrpc-middleware"
+
+// WithTracing starts OpenTelemetry server span for every RPC call. Span name is `serverName.namespace.method`.
+// Parent span is extracted from W3C `traceparent` header of http request. JSON-RPC error code and message are set as span status.
+// Attributes: rpc.system, rpc.service, rpc.method, rpc.jsonrpc.*, ip, platform, version, xRequestId.
+// If tp is nil, global tracer provider is used.
+func WithTracing(tp trace.TracerProvider, serverName string) zenrpc.MiddlewareFunc {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	tracer, propagator := tp.Tracer(tracerName), propagation.TraceContext{}
+
+	return func(h zenrpc.InvokeFunc) zenrpc.InvokeFunc {
+		return func(ctx context.Context, method string, params json.RawMessage) zenrpc.Response {
+			if req, ok := zenrpc.RequestFromContext(ctx); ok && req != nil {
+				ctx = propagator.Extract(ctx, propagation.HeaderCarrier(req.Header))
+			}
+
+			namespace := zenrpc.NamespaceFromContext(ctx)
+			ctx, span := tracer.Start(ctx, fullMethodName(serverName, namespace, method),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(spanAttributes(ctx, namespace, method)...),
+			)
+			defer span.End()
+
+			r := h(ctx, method, params)
+			if r.Error != nil {
+				span.SetAttributes(
+					attribute.Int("rpc.jsonrpc.error_code", r.Error.Code),
+					attribute.String("rpc.jsonrpc.error_message", r.Error.Message),
+				)
+				span.SetStatus(codes.Error, r.Error.Message)
+			}
+
+			return r
+		}
+	}
+}
+
+// spanAttributes returns rpc and appkit context attributes for span.
+func spanAttributes(ctx context.Context, namespace, method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.jsonrpc.version", "2.0"),
+		attribute.String("rpc.service", namespace),
+		attribute.String("rpc.method", method),
+	}
+
+	if id := zenrpc.IDFromContext(ctx); id != nil {
+		attrs = append(attrs, attribute.String("rpc.jsonrpc.request_id", string(*id)))
+	}
+
+	for _, kv := range [][2]string{
+		{"ip", appkit.IPFromContext(ctx)},
+		{"platform", appkit.PlatformFromContext(ctx)},
+		{"version", appkit.VersionFromContext(ctx)},
+		{"xRequestId", appkit.XRequestIDFromContext(ctx)},
+	} {
+		if kv[1] != "" {
+			attrs = append(attrs, attribute.String(kv[0], kv[1]))
+		}
+	}
+
+	return attrs
+}
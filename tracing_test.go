@@ -0,0 +1,76 @@
+package middleware_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vmkteam/zenrpc-middleware"
+
+	"github.com/vmkteam/zenrpc/v2"
+	"github.com/vmkteam/zenrpc/v2/testdata"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddlewareTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	rpc := zenrpc.NewServer(zenrpc.Options{})
+	rpc.Use(
+		middleware.WithHeaders(),
+		middleware.WithTracing(tp, "tracing"),
+	)
+	rpc.Register("arith", testdata.ArithService{})
+
+	ts := httptest.NewServer(http.HandlerFunc(rpc.ServeHTTP))
+	defer ts.Close()
+
+	in := `{"jsonrpc": "2.0", "method": "arith.checkzenrpcerror", "id": 0, "params": [ true ] }`
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, ts.URL, bytes.NewBufferString(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Platform", "Test1")
+	req.Header.Add("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, expected 1", len(spans))
+	}
+
+	s := spans[0]
+	if s.Name != "tracing.arith.checkzenrpcerror" || s.SpanKind != trace.SpanKindServer {
+		t.Errorf("got span name=%s kind=%s", s.Name, s.SpanKind)
+	}
+
+	if s.Parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !s.Parent.IsRemote() {
+		t.Errorf("got parent=%v, expected remote parent from traceparent", s.Parent)
+	}
+
+	if s.Status.Code != codes.Error {
+		t.Errorf("got status=%v, expected error", s.Status)
+	}
+
+	attrs := attribute.NewSet(s.Attributes...)
+	if v, _ := attrs.Value("platform"); v.AsString() != "Test1" {
+		t.Errorf("got platform=%q", v.AsString())
+	}
+
+	if v, _ := attrs.Value("rpc.jsonrpc.error_code"); v.AsInt64() != 500 {
+		t.Errorf("got error code=%d", v.AsInt64())
+	}
+}